@@ -0,0 +1,97 @@
+package goser
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// The math/big types are written in forms their package documents rather
+// than through their internal words: an Int as a sign byte and its
+// big-endian magnitude, a Float in its Gob form (which keeps the precision,
+// rounding mode and accuracy) and a Rat as its "a/b" text.
+
+const (
+	bigIntPositive byte = iota
+	bigIntNegative
+)
+
+func (e *encodeState) marshalBigInt(value *big.Int) []byte {
+	if value == nil {
+		return e.appendOptional(nil, nil, false)
+	}
+	sign := bigIntPositive
+	if value.Sign() < 0 {
+		sign = bigIntNegative
+	}
+	return e.appendOptional(nil, append([]byte{sign}, value.Bytes()...), true)
+}
+
+func (d *decodeState) unmarshalBigInt() (any, error) {
+	payload, present, err := d.readOptional()
+	if err != nil {
+		return nil, fmt.Errorf("can't read big.Int: %w", err)
+	}
+	if !present {
+		return (*big.Int)(nil), nil
+	}
+	if len(payload) == 0 || payload[0] > bigIntNegative {
+		return nil, fmt.Errorf("can't deserialize big.Int with invalid sign")
+	}
+	value := new(big.Int).SetBytes(payload[1:])
+	if payload[0] == bigIntNegative {
+		value.Neg(value)
+	}
+	return value, nil
+}
+
+func (e *encodeState) marshalBigFloat(value *big.Float) ([]byte, error) {
+	if value == nil {
+		return e.appendOptional(nil, nil, false), nil
+	}
+	payload, err := value.GobEncode()
+	if err != nil {
+		return nil, err
+	}
+	return e.appendOptional(nil, payload, true), nil
+}
+
+func (d *decodeState) unmarshalBigFloat() (any, error) {
+	payload, present, err := d.readOptional()
+	if err != nil {
+		return nil, fmt.Errorf("can't read big.Float: %w", err)
+	}
+	if !present {
+		return (*big.Float)(nil), nil
+	}
+	value := new(big.Float)
+	if err := value.GobDecode(payload); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (e *encodeState) marshalBigRat(value *big.Rat) ([]byte, error) {
+	if value == nil {
+		return e.appendOptional(nil, nil, false), nil
+	}
+	payload, err := value.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return e.appendOptional(nil, payload, true), nil
+}
+
+func (d *decodeState) unmarshalBigRat() (any, error) {
+	payload, present, err := d.readOptional()
+	if err != nil {
+		return nil, fmt.Errorf("can't read big.Rat: %w", err)
+	}
+	if !present {
+		return (*big.Rat)(nil), nil
+	}
+	value := new(big.Rat)
+	if err := value.UnmarshalText(payload); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
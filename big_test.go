@@ -0,0 +1,77 @@
+package goser
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestBigInt(t *testing.T) {
+	huge, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	for _, original := range []*big.Int{huge, big.NewInt(0), big.NewInt(42), nil} {
+		decoded, err := UnmarshalT[*big.Int](mustMarshal(t, original))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if (original == nil) != (decoded == nil) || (original != nil && decoded.Cmp(original) != 0) {
+			t.Error(fmt.Errorf("before and after for big.Int %v is not the same: %v", original, decoded))
+		}
+	}
+}
+
+func TestBigFloat(t *testing.T) {
+	original, _, err := big.ParseFloat("3.14159265358979323846264338327950288", 10, 200, big.ToZero)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := UnmarshalT[*big.Float](mustMarshal(t, original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Cmp(original) != 0 || decoded.Prec() != original.Prec() || decoded.Mode() != original.Mode() {
+		t.Error(fmt.Errorf("before and after for big.Float is not the same: %v", decoded))
+	}
+}
+
+func TestBigRat(t *testing.T) {
+	original := big.NewRat(-22, 7)
+	decoded, err := UnmarshalT[*big.Rat](mustMarshal(t, original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Cmp(original) != 0 {
+		t.Error(fmt.Errorf("before and after for big.Rat is not the same: %v", decoded))
+	}
+}
+
+func TestBigInStruct(t *testing.T) {
+	type Invoice struct {
+		Total *big.Int
+		Rate  *big.Rat
+		Items []any
+	}
+	codec := NewCodec()
+	codec.MustRegister(Invoice{})
+	original := Invoice{Total: big.NewInt(1000), Rate: big.NewRat(1, 3), Items: []any{big.NewInt(-5)}}
+	serialized, err := codec.Marshal(original)
+	if err != nil {
+		t.Error(err)
+	}
+	var decoded Invoice
+	if err := codec.UnmarshalInto(serialized, &decoded); err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Error(fmt.Errorf("before and after for Invoice is not the same: %#v", decoded))
+	}
+}
+
+func TestBigIntInvalidSign(t *testing.T) {
+	serialized := mustMarshal(t, big.NewInt(7))
+	serialized[11] = 0xff
+	if _, err := Unmarshal(serialized); err == nil {
+		t.Error(fmt.Errorf("no error raised for invalid sign"))
+	}
+}
@@ -0,0 +1,196 @@
+package goser
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"reflect"
+)
+
+// Codec marshals and unmarshals values using its own type registry, so types
+// registered on one Codec are unknown to every other. The package-level
+// functions use a shared default Codec. A Codec is safe for concurrent use,
+// including registering types while other goroutines marshal.
+type Codec struct {
+	registry     *registry
+	keyedStructs bool
+	omitEmpty    bool
+	references   bool
+	compact      bool
+}
+
+type Option func(*Codec)
+
+var defaultCodec = NewCodec()
+
+func NewCodec(options ...Option) *Codec {
+	c := &Codec{registry: newRegistry()}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Register makes the type of valueOfType known to the codec under an id
+// hashed from its package path and name. It fails if a different type
+// already uses that id. When a type is registered more than once, values are
+// marshalled with the id of the latest registration. Types implementing
+// Marshaler and Unmarshaler, encoding.BinaryMarshaler and
+// encoding.BinaryUnmarshaler, or the Text equivalents, are marshalled
+// through those methods, in that order of preference.
+func (c *Codec) Register(valueOfType any) error {
+	thetype, typeName, err := registrationType(valueOfType)
+	if err != nil {
+		return err
+	}
+	return c.registry.add(typeIdForName(typeName), thetype, false, nil)
+}
+
+// RegisterName is like Register but hashes the given name instead of the
+// type's own, so the id doesn't change when the type is moved or renamed.
+func (c *Codec) RegisterName(valueOfType any, name string) error {
+	thetype, _, err := registrationType(valueOfType)
+	if err != nil {
+		return err
+	}
+	return c.registry.add(typeIdForName(name), thetype, false, nil)
+}
+
+// RegisterWithID is like Register but uses typeId as is.
+func (c *Codec) RegisterWithID(valueOfType any, typeId uint32) error {
+	thetype, _, err := registrationType(valueOfType)
+	if err != nil {
+		return err
+	}
+	return c.registry.add(typeId, thetype, false, nil)
+}
+
+// RegisterAlias makes values tagged with typeId unmarshal as the type of
+// valueOfType without ever marshalling them with it, e.g. to keep reading
+// data written before a type was moved to a new id.
+func (c *Codec) RegisterAlias(valueOfType any, typeId uint32) error {
+	thetype, _, err := registrationType(valueOfType)
+	if err != nil {
+		return err
+	}
+	return c.registry.add(typeId, thetype, true, nil)
+}
+
+// RegisterAliasName is RegisterAlias for the id hashed from name. Passing
+// the former "pkgpath.Name" of a renamed type accepts the ids Register gave
+// it before the rename.
+func (c *Codec) RegisterAliasName(valueOfType any, name string) error {
+	thetype, _, err := registrationType(valueOfType)
+	if err != nil {
+		return err
+	}
+	return c.registry.add(typeIdForName(name), thetype, true, nil)
+}
+
+func registrationType(valueOfType any) (reflect.Type, string, error) {
+	thetype := reflect.TypeOf(valueOfType)
+	if thetype == nil {
+		return nil, "", fmt.Errorf("can't register nil")
+	}
+
+	typeName := registrationName(thetype)
+	if thetype.Name() == "" && thetype.Kind() == reflect.Pointer {
+		thetype = thetype.Elem()
+	}
+	if thetype.Kind() == reflect.Struct {
+		if _, err := cachedStructFields(thetype); err != nil {
+			return nil, "", err
+		}
+	}
+	return thetype, typeName, nil
+}
+
+// registrationName is the name a type's id is hashed from: its package path
+// and name, with a leading "*" for unnamed pointers to named types.
+func registrationName(thetype reflect.Type) string {
+	star := ""
+	named := thetype
+	if named.Name() == "" && named.Kind() == reflect.Pointer {
+		star = "*"
+		named = named.Elem()
+	}
+	if named.Name() == "" {
+		return thetype.String()
+	}
+	if named.PkgPath() == "" {
+		return star + named.Name()
+	}
+	return star + named.PkgPath() + "." + named.Name()
+}
+
+func typeIdForName(typeName string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(typeName))
+	return hash.Sum32()
+}
+
+func (c *Codec) MustRegister(valueOfType any) {
+	if err := c.Register(valueOfType); err != nil {
+		panic(err)
+	}
+}
+
+// RegisteredTypes returns a copy of the codec's registry keyed by type id.
+func (c *Codec) RegisteredTypes() map[uint32]reflect.Type {
+	return c.registry.types()
+}
+
+func (c *Codec) Marshal(obj any) ([]byte, error) {
+	e := &encodeState{codec: c, compact: c.compact}
+	if c.references {
+		e.refs = make(map[refKey]uint64)
+	}
+	serialized, err := e.marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	if c.compact {
+		serialized[0] |= byte(kindCompact)
+	}
+	return serialized, nil
+}
+
+func (c *Codec) Unmarshal(serialized []byte) (any, error) {
+	src := &sliceSource{data: serialized}
+	d := &decodeState{codec: c, src: src}
+	value, err := d.unmarshalValue()
+	if err != nil {
+		return nil, err
+	}
+	if len(src.data) > 0 {
+		return nil, fmt.Errorf("couldn't consume all the provided bytes")
+	}
+	return value, nil
+}
+
+// UnmarshalInto is Unmarshal followed by a checked conversion of the result
+// into target, see the package-level UnmarshalInto.
+func (c *Codec) UnmarshalInto(serialized []byte, target any) error {
+	targetValue, err := intoTarget(target)
+	if err != nil {
+		return err
+	}
+	value, err := c.Unmarshal(serialized)
+	if err != nil {
+		return err
+	}
+	return assign(targetValue, value, "")
+}
+
+func (c *Codec) NewEncoder(writer io.Writer) *Encoder {
+	return &Encoder{codec: c, writer: writer}
+}
+
+func (c *Codec) NewDecoder(reader io.Reader) *Decoder {
+	bufferedReader, ok := reader.(*bufio.Reader)
+	if !ok {
+		bufferedReader = bufio.NewReader(reader)
+	}
+	return &Decoder{codec: c, src: &readerSource{reader: bufferedReader}}
+}
@@ -0,0 +1,84 @@
+package goser
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestCodecIsolatedRegistry(t *testing.T) {
+	type CodecOnly struct {
+		value int
+	}
+	codec := NewCodec()
+	codec.Register(CodecOnly{})
+	serialized, err := codec.Marshal(CodecOnly{value: 3})
+	if err != nil {
+		t.Error(err)
+	}
+	decoded, err := codec.Unmarshal(serialized)
+	if err != nil {
+		t.Error(err)
+	}
+	if decoded.(CodecOnly).value != 3 {
+		t.Error(fmt.Errorf("before and after for CodecOnly is not the same"))
+	}
+	if _, err := Marshal(CodecOnly{}); err == nil {
+		t.Error(fmt.Errorf("no error raised for type registered on another codec"))
+	}
+	if _, err := Unmarshal(serialized); err == nil {
+		t.Error(fmt.Errorf("no error raised for type id registered on another codec"))
+	}
+	if _, err := NewCodec().Unmarshal(serialized); err == nil {
+		t.Error(fmt.Errorf("no error raised for type id registered on another codec"))
+	}
+}
+
+func TestCodecStream(t *testing.T) {
+	type CodecStreamed struct {
+		text string
+	}
+	codec := NewCodec()
+	codec.Register(CodecStreamed{})
+	var buffer bytes.Buffer
+	if err := codec.NewEncoder(&buffer).Encode(CodecStreamed{text: "hi"}); err != nil {
+		t.Error(err)
+	}
+	var decoded CodecStreamed
+	if err := codec.NewDecoder(&buffer).DecodeInto(&decoded); err != nil {
+		t.Error(err)
+	}
+	if decoded.text != "hi" {
+		t.Error(fmt.Errorf("before and after for CodecStreamed is not the same"))
+	}
+}
+
+func TestCodecConcurrentRegister(t *testing.T) {
+	type Concurrent0 struct{ a int }
+	type Concurrent1 struct{ b string }
+	type Concurrent2 struct{ c []int }
+	type Concurrent3 struct{ d float32 }
+	samples := []any{Concurrent0{a: 1}, Concurrent1{b: "b"}, Concurrent2{c: []int{2}}, Concurrent3{d: 3}}
+	codec := NewCodec()
+	var wg sync.WaitGroup
+	for _, sample := range samples {
+		wg.Add(1)
+		go func(sample any) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				codec.Register(sample)
+				serialized, err := codec.Marshal(sample)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := codec.Unmarshal(serialized); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(sample)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,134 @@
+package goser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// kindCompact is set on the first byte of a value marshalled by a codec
+// created WithCompact. Every length inside such a value is an unsigned
+// LEB128 varint, and so is every unsigned integer wider than a byte, while
+// signed ones are zig-zag varints. Values without the flag keep the fixed
+// 8-byte lengths and little-endian integers, so either is unmarshalled.
+const kindCompact reflect.Kind = 0x40
+
+// WithCompact makes the codec marshal lengths and integers as varints,
+// which keeps small numbers and short collections small.
+func WithCompact() Option {
+	return func(c *Codec) {
+		c.compact = true
+	}
+}
+
+// unmarshalValue unmarshals a value as Marshal wrote it, taking the integer
+// encoding from its first byte.
+func (d *decodeState) unmarshalValue() (any, error) {
+	kind, err := d.readKind()
+	if err != nil {
+		return nil, err
+	}
+	d.compact = kind&kindCompact != 0
+	return d.unmarshalKind(kind &^ kindCompact)
+}
+
+func (e *encodeState) appendLength(serialized []byte, length int) []byte {
+	if e.compact {
+		return binary.AppendUvarint(serialized, uint64(length))
+	}
+	return appendLength(serialized, length)
+}
+
+func (d *decodeState) readLength() (uint64, error) {
+	if d.compact {
+		return d.readUvarint()
+	}
+	return readLength(d.src)
+}
+
+func (d *decodeState) readUvarint() (uint64, error) {
+	var value uint64
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		encodedByte, err := d.src.next(1)
+		if err != nil {
+			return 0, err
+		}
+		if i == binary.MaxVarintLen64-1 && encodedByte[0] > 1 {
+			break
+		}
+		value |= uint64(encodedByte[0]&0x7f) << (7 * i)
+		if encodedByte[0] < 0x80 {
+			return value, nil
+		}
+	}
+	return 0, fmt.Errorf("varint overflows 64 bits")
+}
+
+func marshalCompactInt(kind reflect.Kind, value reflect.Value) ([]byte, bool) {
+	switch kind {
+	case reflect.Int, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(nil, value.Int()), true
+	case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return binary.AppendUvarint(nil, value.Uint()), true
+	}
+	return nil, false
+}
+
+func (d *decodeState) unmarshalCompactInt(kind reflect.Kind) (any, bool, error) {
+	switch kind {
+	case reflect.Int, reflect.Int16, reflect.Int32, reflect.Int64:
+	case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+	default:
+		return nil, false, nil
+	}
+	encoded, err := d.readUvarint()
+	if err != nil {
+		return nil, true, fmt.Errorf("can't read %v: %w", kind, err)
+	}
+	signed := int64(encoded >> 1)
+	if encoded&1 != 0 {
+		signed = ^signed
+	}
+	overflow := func() (any, bool, error) {
+		return nil, true, fmt.Errorf("can't deserialize %v as %v overflows it", encoded, kind)
+	}
+	switch kind {
+	case reflect.Int:
+		if signed < math.MinInt || signed > math.MaxInt {
+			return overflow()
+		}
+		return int(signed), true, nil
+	case reflect.Int16:
+		if signed < math.MinInt16 || signed > math.MaxInt16 {
+			return overflow()
+		}
+		return int16(signed), true, nil
+	case reflect.Int32:
+		if signed < math.MinInt32 || signed > math.MaxInt32 {
+			return overflow()
+		}
+		return int32(signed), true, nil
+	case reflect.Int64:
+		return signed, true, nil
+	case reflect.Uint:
+		if encoded > math.MaxUint {
+			return overflow()
+		}
+		return uint(encoded), true, nil
+	case reflect.Uint16:
+		if encoded > math.MaxUint16 {
+			return overflow()
+		}
+		return uint16(encoded), true, nil
+	case reflect.Uint32:
+		if encoded > math.MaxUint32 {
+			return overflow()
+		}
+		return uint32(encoded), true, nil
+	case reflect.Uint64:
+		return encoded, true, nil
+	default:
+		return uintptr(encoded), true, nil
+	}
+}
@@ -0,0 +1,129 @@
+package goser
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompactRoundTrip(t *testing.T) {
+	type Sample struct {
+		Name    string
+		Counts  map[string]int
+		Signed  []any
+		Missing []int
+		At      time.Time
+		Total   *big.Int
+		Address netip.Addr
+	}
+	codec := NewCodec(WithCompact())
+	codec.MustRegister(Sample{})
+	original := Sample{
+		Name:   "compact",
+		Counts: map[string]int{"a": 1, "b": -300},
+		Signed: []any{
+			int(math.MinInt64), int16(math.MinInt16), int32(math.MaxInt32), int64(-1),
+			uint(math.MaxUint64), uint16(math.MaxUint16), uint32(7), uint64(1 << 40), uintptr(3),
+			int8(-8), uint8(8), 1.5, "x",
+		},
+		At:      time.Date(2024, 5, 6, 7, 8, 9, 10, time.UTC),
+		Total:   big.NewInt(-12345),
+		Address: netip.MustParseAddr("2001:db8::1"),
+	}
+	serialized, err := codec.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serialized[0] != byte(reflect.Struct|kindCompact) {
+		t.Error(fmt.Errorf("compact value not flagged: % x", serialized[:1]))
+	}
+	var decoded Sample
+	if err := codec.UnmarshalInto(serialized, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Error(fmt.Errorf("before and after for Sample is not the same: %#v", decoded))
+	}
+}
+
+func TestCompactSize(t *testing.T) {
+	codec := NewCodec(WithCompact())
+	serialized, err := codec.Marshal([]int{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(serialized) != 1+1+2+3*2 {
+		t.Error(fmt.Errorf("unexpected compact size %v: % x", len(serialized), serialized))
+	}
+	serialized, err = codec.Marshal("hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(serialized) != 1+1+2 {
+		t.Error(fmt.Errorf("unexpected compact size %v: % x", len(serialized), serialized))
+	}
+}
+
+func TestCompactMixedModes(t *testing.T) {
+	compact := NewCodec(WithCompact())
+	fixed := NewCodec()
+	original := map[string][]int64{"a": {-1, 1 << 50}}
+	var buffer bytes.Buffer
+	if err := compact.NewEncoder(&buffer).Encode(original); err != nil {
+		t.Fatal(err)
+	}
+	if err := fixed.NewEncoder(&buffer).Encode(original); err != nil {
+		t.Fatal(err)
+	}
+	for _, codec := range []*Codec{compact, fixed} {
+		decoder := codec.NewDecoder(bytes.NewReader(buffer.Bytes()))
+		for i := 0; i < 2; i++ {
+			decoded, err := decoder.Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, original) {
+				t.Error(fmt.Errorf("before and after for value %v is not the same: %#v", i, decoded))
+			}
+		}
+	}
+}
+
+func TestCompactReferences(t *testing.T) {
+	codec := NewCodec(WithCompact(), WithReferences())
+	shared := []string{"a"}
+	serialized, err := codec.Marshal([][]string{shared, shared})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := codec.Unmarshal(serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := decoded.([][]string)
+	items[0][0] = "b"
+	if items[1][0] != "b" {
+		t.Error(fmt.Errorf("shared slice not shared after decoding: %#v", items))
+	}
+}
+
+func TestCompactOverflow(t *testing.T) {
+	codec := NewCodec(WithCompact())
+	serialized, err := codec.Marshal(int64(math.MaxInt16 + 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialized[0] = byte(reflect.Int16 | kindCompact)
+	if _, err := codec.Unmarshal(serialized); err == nil {
+		t.Error(fmt.Errorf("no error raised for int16 overflow"))
+	}
+	tooLong := append([]byte{byte(reflect.Uint64 | kindCompact)}, bytes.Repeat([]byte{0xff}, 10)...)
+	if _, err := codec.Unmarshal(append(tooLong, 0x01)); err == nil {
+		t.Error(fmt.Errorf("no error raised for varint overflow"))
+	}
+}
@@ -0,0 +1,78 @@
+package goser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+)
+
+// customCodec encodes values of a type the codec can't add methods to.
+type customCodec struct {
+	encode func(value any) ([]byte, error)
+	decode func(payload []byte) (any, error)
+}
+
+// RegisterCodec registers the type of typeExample to be marshalled with
+// encode and unmarshalled with decode instead of by its kind. A type that
+// is already registered, e.g. with RegisterWithID or RegisterName, keeps
+// the id it is marshalled with, others get the id Register would give them. decode has to return a value of that
+// same type. Nil pointers are written without calling encode. A codec takes
+// precedence over the package's own handling of a type, such as time.Time.
+func (c *Codec) RegisterCodec(typeExample any, encode func(value any) ([]byte, error), decode func(payload []byte) (any, error)) error {
+	theType := reflect.TypeOf(typeExample)
+	if theType == nil {
+		return fmt.Errorf("can't register nil")
+	}
+	if encode == nil || decode == nil {
+		return fmt.Errorf("can't register codec for %v without both functions", theType)
+	}
+	return c.registry.addCodec(typeIdForName(registrationName(theType)), theType, &customCodec{encode: encode, decode: decode})
+}
+
+func RegisterCodec(typeExample any, encode func(value any) ([]byte, error), decode func(payload []byte) (any, error)) error {
+	return defaultCodec.RegisterCodec(typeExample, encode, decode)
+}
+
+// Values with a custom codec are written as tagCustom and their type id,
+// followed by the payload as appendOptional writes it.
+func (e *encodeState) marshalCustom(typeId uint32, custom *customCodec, value reflect.Value) ([]byte, error) {
+	serialized := []byte{byte(tagCustom)}
+	encodedTypeId := make([]byte, 4)
+	binary.LittleEndian.PutUint32(encodedTypeId, typeId)
+	serialized = append(serialized, encodedTypeId...)
+	if value.Kind() == reflect.Pointer && value.IsNil() {
+		return e.appendOptional(serialized, nil, false), nil
+	}
+	payload, err := custom.encode(value.Interface())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't serialize %v: %w", value.Type(), err)
+	}
+	return e.appendOptional(serialized, payload, true), nil
+}
+
+func (d *decodeState) unmarshalCustom() (any, error) {
+	encodedTypeId, err := d.src.next(4)
+	if err != nil {
+		return nil, fmt.Errorf("can't read type id: %w", err)
+	}
+	typeId := binary.LittleEndian.Uint32(encodedTypeId)
+	theType, custom, hasCodec := d.codec.registry.codecById(typeId)
+	if !hasCodec {
+		return nil, fmt.Errorf("can't deserialize type id %v (no codec registered)", typeId)
+	}
+	payload, present, err := d.readOptional()
+	if err != nil {
+		return nil, fmt.Errorf("can't read %v: %w", theType, err)
+	}
+	if !present {
+		return reflect.Zero(theType).Interface(), nil
+	}
+	value, err := custom.decode(payload)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't deserialize %v: %w", theType, err)
+	}
+	if reflect.TypeOf(value) != theType {
+		return nil, &UnmarshalTypeError{Got: reflect.TypeOf(value), Want: theType}
+	}
+	return value, nil
+}
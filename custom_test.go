@@ -0,0 +1,161 @@
+package goser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newURLCodec(t *testing.T) *Codec {
+	codec := NewCodec()
+	err := RegisterCodecTFor(codec, func(value *url.URL) ([]byte, error) {
+		return []byte(value.String()), nil
+	}, func(payload []byte) (*url.URL, error) {
+		return url.Parse(string(payload))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return codec
+}
+
+func TestRegisterCodec(t *testing.T) {
+	type Request struct {
+		Target   *url.URL
+		Referrer *url.URL
+		Mirrors  []*url.URL
+	}
+	codec := newURLCodec(t)
+	codec.MustRegister(Request{})
+	original := Request{Target: &url.URL{Scheme: "https", Host: "example.com", Path: "/a b"}}
+	serialized, err := codec.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Request
+	if err := codec.UnmarshalInto(serialized, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Error(fmt.Errorf("before and after for Request is not the same: %#v", decoded))
+	}
+}
+
+func TestRegisterCodecOverridesTime(t *testing.T) {
+	codec := NewCodec()
+	err := codec.RegisterCodec(time.Time{}, func(value any) ([]byte, error) {
+		return value.(time.Time).MarshalText()
+	}, func(payload []byte) (any, error) {
+		var decoded time.Time
+		err := decoded.UnmarshalText(payload)
+		return decoded, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	serialized, err := codec.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serialized[0] != byte(tagCustom) {
+		t.Error(fmt.Errorf("time not written through its codec: % x", serialized))
+	}
+	decoded, err := codec.Unmarshal(serialized)
+	if err != nil {
+		t.Error(err)
+	}
+	if !decoded.(time.Time).Equal(original) {
+		t.Error(fmt.Errorf("before and after for time is not equal: %v", decoded))
+	}
+}
+
+func TestRegisterCodecWrongType(t *testing.T) {
+	codec := NewCodec()
+	err := codec.RegisterCodec(url.URL{}, func(value any) ([]byte, error) {
+		return nil, nil
+	}, func(payload []byte) (any, error) {
+		return &url.URL{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialized, err := codec.Marshal(url.URL{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = codec.Unmarshal(serialized)
+	var typeErr *UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		t.Error(fmt.Errorf("expected *UnmarshalTypeError, got %v", err))
+	}
+}
+
+func TestRegisterCodecKeepsID(t *testing.T) {
+	type Point struct {
+		x, y int
+	}
+	encode := func(value any) ([]byte, error) {
+		point := value.(Point)
+		return []byte{byte(point.x), byte(point.y)}, nil
+	}
+	decode := func(payload []byte) (any, error) {
+		return Point{x: int(payload[0]), y: int(payload[1])}, nil
+	}
+	pinned := NewCodec()
+	pinned.RegisterWithID(Point{}, 42)
+	named := NewCodec()
+	named.RegisterName(Point{}, "geometry.Point")
+	expected := map[*Codec]uint32{
+		pinned:     42,
+		named:      typeIdForName("geometry.Point"),
+		NewCodec(): typeIdForName("github.com/ejobsgroup/goser.Point"),
+	}
+	for codec, typeId := range expected {
+		if err := codec.RegisterCodec(Point{}, encode, decode); err != nil {
+			t.Fatal(err)
+		}
+		serialized, err := codec.Marshal(Point{x: 1, y: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := binary.LittleEndian.Uint32(serialized[1:5]); got != typeId {
+			t.Error(fmt.Errorf("expected id %#08x, got %#08x", typeId, got))
+		}
+		decoded, err := codec.Unmarshal(serialized)
+		if err != nil {
+			t.Error(err)
+		}
+		if decoded != (Point{x: 1, y: 2}) {
+			t.Error(fmt.Errorf("before and after for Point is not the same: %#v", decoded))
+		}
+	}
+}
+
+func TestRegisterCodecMissingFunction(t *testing.T) {
+	if NewCodec().RegisterCodec(url.URL{}, nil, nil) == nil {
+		t.Error(fmt.Errorf("no error raised"))
+	}
+}
+
+func TestRegisterCodecNilSlice(t *testing.T) {
+	codec := newURLCodec(t)
+	serialized, err := codec.Marshal([]*url.URL(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serialized[0] != byte(tagNil) {
+		t.Error(fmt.Errorf("nil slice not written as nil: % x", serialized))
+	}
+	decoded, err := codec.Unmarshal(serialized)
+	if err != nil {
+		t.Error(err)
+	}
+	if decoded, ok := decoded.([]*url.URL); !ok || decoded != nil {
+		t.Error(fmt.Errorf("nil slice decoded as %#v", decoded))
+	}
+}
@@ -0,0 +1,39 @@
+package goser
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// MarshalT is Marshal for a statically known type.
+func MarshalT[T any](obj T) ([]byte, error) {
+	return Marshal(obj)
+}
+
+// UnmarshalT decodes serialized as a T. Values that don't fit T are reported
+// as an *UnmarshalTypeError instead of failing a type assertion.
+func UnmarshalT[T any](serialized []byte) (T, error) {
+	var value T
+	if err := UnmarshalInto(serialized, &value); err != nil {
+		var zero T
+		return zero, err
+	}
+	return value, nil
+}
+
+// RegisterCodecT is RegisterCodec for a statically known type.
+func RegisterCodecT[T any](encode func(value T) ([]byte, error), decode func(payload []byte) (T, error)) error {
+	return RegisterCodecTFor(defaultCodec, encode, decode)
+}
+
+// RegisterCodecTFor is RegisterCodecT for the given codec.
+func RegisterCodecTFor[T any](c *Codec, encode func(value T) ([]byte, error), decode func(payload []byte) (T, error)) error {
+	if encode == nil || decode == nil {
+		return fmt.Errorf("can't register codec for %v without both functions", reflect.TypeOf((*T)(nil)).Elem())
+	}
+	return c.RegisterCodec(*new(T), func(value any) ([]byte, error) {
+		return encode(value.(T))
+	}, func(payload []byte) (any, error) {
+		return decode(payload)
+	})
+}
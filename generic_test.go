@@ -0,0 +1,57 @@
+package goser
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestGenericRoundTrip(t *testing.T) {
+	type Score float64
+	type GenericContainer struct {
+		scores []Score
+	}
+	Register(GenericContainer{})
+	bytes, err := MarshalT(&GenericContainer{scores: []Score{1.5}})
+	if err != nil {
+		t.Error(err)
+	}
+	container, err := UnmarshalT[*GenericContainer](bytes)
+	if err != nil {
+		t.Error(err)
+	}
+	if container == nil || container.scores[0] != 1.5 {
+		t.Error(fmt.Errorf("before and after for *GenericContainer is not the same"))
+	}
+	scores, err := UnmarshalT[[]Score](mustMarshal(t, []float64{2.5}))
+	if err != nil {
+		t.Error(err)
+	}
+	if len(scores) != 1 || scores[0] != 2.5 {
+		t.Error(fmt.Errorf("before and after for []Score is not the same"))
+	}
+}
+
+func TestGenericMismatch(t *testing.T) {
+	bytes, err := MarshalT("text")
+	if err != nil {
+		t.Error(err)
+	}
+	value, err := UnmarshalT[int](bytes)
+	var typeErr *UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		t.Error(fmt.Errorf("expected *UnmarshalTypeError, got %v", err))
+	}
+	if value != 0 {
+		t.Error(fmt.Errorf("expected zero value on error, got %v", value))
+	}
+}
+
+func mustMarshal(t *testing.T, obj any) []byte {
+	t.Helper()
+	bytes, err := Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes
+}
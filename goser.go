@@ -3,52 +3,79 @@ package goser
 import (
 	"encoding/binary"
 	"fmt"
-	"hash/fnv"
 	"math"
 	"reflect"
-	"time"
 	"unsafe"
 )
 
-var idToType map[uint32]reflect.Type
-var typeToId map[reflect.Type]uint32
+// Tags above the reflect.Kind range mark encodings that don't map to a kind
+// of their own. They stay below 0xc0 as 0x40 is kindCompact.
+const (
+	tagKeyedStruct reflect.Kind = 0x80 + iota
+	tagZero
+	tagInterface
+	tagNamed
+	tagNil
+	tagTracked
+	tagRef
+	tagWellKnown
+	tagBinary
+	tagText
+	tagGoser
+	tagCustom
+)
+
+// Pointers are written with one of these after their kind. A typed nil is
+// followed by the type of the pointed to value, see marshalType.
+const (
+	pointerNil byte = iota
+	pointerSet
+	pointerTypedNil
+)
 
-func init() {
-	idToType = make(map[uint32]reflect.Type)
-	typeToId = make(map[reflect.Type]uint32)
+func Register(valueOfType any) error {
+	return defaultCodec.Register(valueOfType)
 }
 
-func Register(valueOfType any) {
-	thetype := reflect.TypeOf(valueOfType)
+func RegisterName(valueOfType any, name string) error {
+	return defaultCodec.RegisterName(valueOfType, name)
+}
 
-	typeName := thetype.String()
-	star := ""
-	if thetype.Name() == "" {
-		if thetype.Kind() == reflect.Pointer {
-			star = "*"
-			thetype = thetype.Elem()
-		}
-	}
-	if thetype.Name() != "" {
-		if thetype.PkgPath() == "" {
-			typeName = star + thetype.Name()
-		} else {
-			typeName = star + thetype.PkgPath() + "." + thetype.Name()
-		}
-	}
+func RegisterWithID(valueOfType any, typeId uint32) error {
+	return defaultCodec.RegisterWithID(valueOfType, typeId)
+}
+
+func RegisterAlias(valueOfType any, typeId uint32) error {
+	return defaultCodec.RegisterAlias(valueOfType, typeId)
+}
 
-	hash := fnv.New32a()
-	hash.Write([]byte(typeName))
-	typeId := hash.Sum32()
+func RegisterAliasName(valueOfType any, name string) error {
+	return defaultCodec.RegisterAliasName(valueOfType, name)
+}
 
-	_, typeKnown := idToType[typeId]
-	if !typeKnown {
-		idToType[typeId] = thetype
-		typeToId[thetype] = typeId
-	}
+func MustRegister(valueOfType any) {
+	defaultCodec.MustRegister(valueOfType)
+}
+
+func RegisteredTypes() map[uint32]reflect.Type {
+	return defaultCodec.RegisteredTypes()
 }
 
 func Marshal(obj any) ([]byte, error) {
+	return defaultCodec.Marshal(obj)
+}
+
+func Unmarshal(serialized []byte) (any, error) {
+	return defaultCodec.Unmarshal(serialized)
+}
+
+type encodeState struct {
+	codec   *Codec
+	refs    map[refKey]uint64
+	compact bool
+}
+
+func (e *encodeState) marshal(obj any) ([]byte, error) {
 	thetype := reflect.TypeOf(obj)
 	var kind reflect.Kind
 	if thetype == nil {
@@ -57,8 +84,28 @@ func Marshal(obj any) ([]byte, error) {
 		kind = thetype.Kind()
 	}
 	value := reflect.ValueOf(obj)
+	typeId, custom, typeKnown := e.codec.registry.lookup(thetype)
+	if custom != nil {
+		return e.marshalCustom(typeId, custom, value)
+	}
+	if wellKnownId, isWellKnown := wellKnownIds[thetype]; isWellKnown {
+		return e.marshalWellKnown(wellKnownId, value)
+	}
+	if typeKnown {
+		if tag := marshalerTag(thetype); tag != 0 {
+			return e.marshalMarshaler(tag, typeId, value)
+		}
+		if thetype.PkgPath() != "" && kind != reflect.Struct {
+			return e.marshalNamed(typeId, value)
+		}
+	}
 	serialized := make([]byte, 0)
 	serialized = append(serialized, byte(kind))
+	if e.compact {
+		if encodedInt, isInt := marshalCompactInt(kind, value); isInt {
+			return append(serialized, encodedInt...), nil
+		}
+	}
 	switch kind {
 	case reflect.Bool:
 		if value.Bool() {
@@ -131,73 +178,96 @@ func Marshal(obj any) ([]byte, error) {
 		binary.LittleEndian.PutUint64(encodedComplex128[8:], math.Float64bits(objImag64))
 		serialized = append(serialized, encodedComplex128...)
 	case reflect.String:
-		encodedLength := make([]byte, 8)
-		length := value.Len()
-		binary.LittleEndian.PutUint64(encodedLength, uint64(length))
-		serialized = append(serialized, encodedLength...)
+		serialized = e.appendLength(serialized, value.Len())
 		serialized = append(serialized, []byte(value.Interface().(string))...)
 	case reflect.Pointer:
 		if obj != nil && !value.IsNil() {
-			serialized = append(serialized, 1)
-			encodedPointerContents, err := Marshal(value.Elem().Interface())
+			if e.refs != nil {
+				return e.marshalTrackedPointer(value)
+			}
+			serialized = append(serialized, pointerSet)
+			encodedPointerContents, err := e.marshal(value.Elem().Interface())
 			if err != nil {
 				return nil, fmt.Errorf("couldn't serialize pointer contents: %w", err)
 			}
 			serialized = append(serialized, encodedPointerContents...)
+		} else if obj != nil {
+			encodedType, err := e.marshalType(thetype.Elem())
+			if err != nil {
+				// Nothing describes the type, so fall back to an untyped nil.
+				serialized = append(serialized, pointerNil)
+			} else {
+				serialized = append(serialized, pointerTypedNil)
+				serialized = append(serialized, encodedType...)
+			}
 		} else {
-			serialized = append(serialized, 0)
+			serialized = append(serialized, pointerNil)
 		}
 	case reflect.Array:
-		encodedLength := make([]byte, 8)
 		length := value.Len()
-		binary.LittleEndian.PutUint64(encodedLength, uint64(length))
-		serialized = append(serialized, encodedLength...)
-		typeMarker := reflect.Zero(thetype.Elem())
-		encodedTypeMarker, err := Marshal(typeMarker.Interface())
+		serialized = e.appendLength(serialized, length)
+		encodedTypeMarker, err := e.marshalTypeMarker(thetype.Elem())
 		if err != nil {
 			return nil, fmt.Errorf("couldn't serialize array type marker: %w", err)
 		}
 		serialized = append(serialized, encodedTypeMarker...)
 		for i := 0; i < length; i++ {
 			item := value.Index(i)
-			encodedItem, err := Marshal(item.Interface())
+			encodedItem, err := e.marshal(item.Interface())
 			if err != nil {
 				return nil, fmt.Errorf("couldn't serialize array item: %w", err)
 			}
 			serialized = append(serialized, encodedItem...)
 		}
 	case reflect.Slice:
-		encodedLength := make([]byte, 8)
+		if value.IsNil() {
+			if encodedNil, err := e.marshalNil(thetype); err == nil {
+				return encodedNil, nil
+			}
+		}
+		if e.refs != nil {
+			prefix, seen := e.trackRef(value)
+			if seen {
+				return prefix, nil
+			}
+			serialized = append(prefix, serialized...)
+		}
 		length := value.Len()
-		binary.LittleEndian.PutUint64(encodedLength, uint64(length))
-		serialized = append(serialized, encodedLength...)
-		typeMarker := reflect.Zero(thetype.Elem())
-		encodedTypeMarker, err := Marshal(typeMarker.Interface())
+		serialized = e.appendLength(serialized, length)
+		encodedTypeMarker, err := e.marshalTypeMarker(thetype.Elem())
 		if err != nil {
 			return nil, fmt.Errorf("couldn't serialize slice type marker: %w", err)
 		}
 		serialized = append(serialized, encodedTypeMarker...)
 		for i := 0; i < length; i++ {
 			item := value.Index(i)
-			encodedItem, err := Marshal(item.Interface())
+			encodedItem, err := e.marshal(item.Interface())
 			if err != nil {
 				return nil, fmt.Errorf("couldn't serialize slice item: %w", err)
 			}
 			serialized = append(serialized, encodedItem...)
 		}
 	case reflect.Map:
-		encodedLength := make([]byte, 8)
+		if value.IsNil() {
+			if encodedNil, err := e.marshalNil(thetype); err == nil {
+				return encodedNil, nil
+			}
+		}
+		if e.refs != nil {
+			prefix, seen := e.trackRef(value)
+			if seen {
+				return prefix, nil
+			}
+			serialized = append(prefix, serialized...)
+		}
 		length := value.Len()
-		binary.LittleEndian.PutUint64(encodedLength, uint64(length))
-		serialized = append(serialized, encodedLength...)
-		keyTypeMarker := reflect.Zero(thetype.Key())
-		encodedKeyTypeMarker, err := Marshal(keyTypeMarker.Interface())
+		serialized = e.appendLength(serialized, length)
+		encodedKeyTypeMarker, err := e.marshalTypeMarker(thetype.Key())
 		if err != nil {
 			return nil, fmt.Errorf("couldn't serialize map key type marker: %w", err)
 		}
 		serialized = append(serialized, encodedKeyTypeMarker...)
-		valueTypeMarker := reflect.Zero(thetype.Elem())
-		encodedValueTypeMarker, err := Marshal(valueTypeMarker.Interface())
+		encodedValueTypeMarker, err := e.marshalTypeMarker(thetype.Elem())
 		if err != nil {
 			return nil, fmt.Errorf("couldn't serialize map value type marker: %w", err)
 		}
@@ -205,43 +275,47 @@ func Marshal(obj any) ([]byte, error) {
 		mapRange := value.MapRange()
 		for mapRange.Next() {
 			key := mapRange.Key()
-			encodedKey, err := Marshal(key.Interface())
+			encodedKey, err := e.marshal(key.Interface())
 			if err != nil {
 				return nil, fmt.Errorf("couldn't serialize map key: %w", err)
 			}
 			serialized = append(serialized, encodedKey...)
 			value := mapRange.Value()
-			encodedValue, err := Marshal(value.Interface())
+			encodedValue, err := e.marshal(value.Interface())
 			if err != nil {
 				return nil, fmt.Errorf("couldn't serialize map value: %w", err)
 			}
 			serialized = append(serialized, encodedValue...)
 		}
 	case reflect.Struct:
-		if timeObj, ok := obj.(time.Time); ok {
-			encodedTypeId := []byte("time")
-			serialized = append(serialized, encodedTypeId...)
-			encodedField, _ := Marshal(timeObj.UnixMicro())
-			serialized = append(serialized, encodedField...)
-		} else {
-			typeId, typeKnown := typeToId[thetype]
-			if !typeKnown {
-				return nil, fmt.Errorf("can't serialize type %v (not registered)", thetype)
+		typeId, typeKnown := e.codec.registry.idByType(thetype)
+		if !typeKnown {
+			return nil, fmt.Errorf("can't serialize type %v (not registered)", thetype)
+		}
+		fields, err := cachedStructFields(thetype)
+		if err != nil {
+			return nil, err
+		}
+		if e.codec.keyedStructs {
+			return e.marshalKeyedStruct(typeId, fields, value)
+		}
+		encodedTypeId := make([]byte, 4)
+		binary.LittleEndian.PutUint32(encodedTypeId, typeId)
+		serialized = append(serialized, encodedTypeId...)
+		structCopy := reflect.New(thetype).Elem()
+		structCopy.Set(value)
+		for _, field := range fields.list {
+			unsafeField := structCopy.Field(field.index)
+			unsafeField = reflect.NewAt(unsafeField.Type(), unsafe.Pointer(unsafeField.UnsafeAddr())).Elem()
+			if e.omitField(field, unsafeField) {
+				serialized = append(serialized, byte(tagZero))
+				continue
 			}
-			encodedTypeId := make([]byte, 4)
-			binary.LittleEndian.PutUint32(encodedTypeId, typeId)
-			serialized = append(serialized, encodedTypeId...)
-			for i := 0; i < value.NumField(); i++ {
-				structCopy := reflect.New(thetype).Elem()
-				structCopy.Set(value)
-				unsafeField := structCopy.Field(i)
-				unsafeField = reflect.NewAt(unsafeField.Type(), unsafe.Pointer(unsafeField.UnsafeAddr())).Elem()
-				encodedField, err := Marshal(unsafeField.Interface())
-				if err != nil {
-					return nil, fmt.Errorf("couldn't serialize struct field: %w", err)
-				}
-				serialized = append(serialized, encodedField...)
+			encodedField, err := e.marshal(unsafeField.Interface())
+			if err != nil {
+				return nil, fmt.Errorf("couldn't serialize struct field: %w", err)
 			}
+			serialized = append(serialized, encodedField...)
 		}
 	case reflect.Chan:
 		return nil, fmt.Errorf("can't serialize channel (%v)", kind)
@@ -251,263 +325,443 @@ func Marshal(obj any) ([]byte, error) {
 	return serialized, nil
 }
 
-func Unmarshal(serialized []byte) (any, error) {
-	value, serialized, err := unmarshalRecursive(serialized)
-	if len(serialized) > 0 {
-		return nil, fmt.Errorf("couldn't consume all the provided bytes")
+type decodeState struct {
+	codec    *Codec
+	src      source
+	refs     map[uint64]reflect.Value
+	refOrder []uint64
+	compact  bool
+}
+
+func (d *decodeState) unmarshalRecursive() (any, error) {
+	kind, err := d.readKind()
+	if err != nil {
+		return nil, err
 	}
-	return value, err
+	return d.unmarshalKind(kind)
 }
 
-func unmarshalRecursive(serialized []byte) (any, []byte, error) {
-	if len(serialized) < 1 {
-		return nil, nil, fmt.Errorf("can't read kind %v", serialized)
+func (d *decodeState) readKind() (reflect.Kind, error) {
+	encodedKind, err := d.src.next(1)
+	if err != nil {
+		return 0, fmt.Errorf("can't read kind: %w", err)
 	}
-	kind := reflect.Kind(serialized[0])
-	serialized = serialized[1:]
+	return reflect.Kind(encodedKind[0]), nil
+}
 
+func (d *decodeState) unmarshalKind(kind reflect.Kind) (any, error) {
+	if d.compact {
+		if value, isInt, err := d.unmarshalCompactInt(kind); isInt {
+			return value, err
+		}
+	}
 	switch kind {
 	case reflect.Bool:
-		if len(serialized) < 1 {
-			return nil, nil, fmt.Errorf("can't read bool %v", serialized)
-		}
-		if serialized[0] == 1 {
-			return true, serialized[1:], nil
+		encodedBool, err := d.src.next(1)
+		if err != nil {
+			return nil, fmt.Errorf("can't read bool: %w", err)
 		}
-		return false, serialized[1:], nil
+		return encodedBool[0] == 1, nil
 	case reflect.Int:
-		if len(serialized) < 8 {
-			return nil, nil, fmt.Errorf("can't read int %v", serialized)
+		encodedInt, err := d.src.next(8)
+		if err != nil {
+			return nil, fmt.Errorf("can't read int: %w", err)
 		}
-		return int(binary.LittleEndian.Uint64(serialized[:8])), serialized[8:], nil
+		return int(binary.LittleEndian.Uint64(encodedInt)), nil
 	case reflect.Int8:
-		if len(serialized) < 1 {
-			return nil, nil, fmt.Errorf("can't read int8 %v", serialized)
+		encodedInt8, err := d.src.next(1)
+		if err != nil {
+			return nil, fmt.Errorf("can't read int8: %w", err)
 		}
-		return serialized[0], serialized[1:], nil
+		return int8(encodedInt8[0]), nil
 	case reflect.Int16:
-		if len(serialized) < 2 {
-			return nil, nil, fmt.Errorf("can't read int16 %v", serialized)
+		encodedInt16, err := d.src.next(2)
+		if err != nil {
+			return nil, fmt.Errorf("can't read int16: %w", err)
 		}
-		return int16(binary.LittleEndian.Uint16(serialized[:2])), serialized[2:], nil
+		return int16(binary.LittleEndian.Uint16(encodedInt16)), nil
 	case reflect.Int32:
-		if len(serialized) < 4 {
-			return nil, nil, fmt.Errorf("can't read int32 %v", serialized)
+		encodedInt32, err := d.src.next(4)
+		if err != nil {
+			return nil, fmt.Errorf("can't read int32: %w", err)
 		}
-		return int32(binary.LittleEndian.Uint32(serialized[:4])), serialized[4:], nil
+		return int32(binary.LittleEndian.Uint32(encodedInt32)), nil
 	case reflect.Int64:
-		if len(serialized) < 8 {
-			return nil, nil, fmt.Errorf("can't read int64 %v", serialized)
+		encodedInt64, err := d.src.next(8)
+		if err != nil {
+			return nil, fmt.Errorf("can't read int64: %w", err)
 		}
-		return int64(binary.LittleEndian.Uint64(serialized[:8])), serialized[8:], nil
+		return int64(binary.LittleEndian.Uint64(encodedInt64)), nil
 	case reflect.Uint:
-		if len(serialized) < 8 {
-			return nil, nil, fmt.Errorf("can't read uint %v", serialized)
+		encodedUint, err := d.src.next(8)
+		if err != nil {
+			return nil, fmt.Errorf("can't read uint: %w", err)
 		}
-		return uint(binary.LittleEndian.Uint64(serialized[:8])), serialized[8:], nil
+		return uint(binary.LittleEndian.Uint64(encodedUint)), nil
 	case reflect.Uint8:
-		if len(serialized) < 1 {
-			return nil, nil, fmt.Errorf("can't read uint8 %v", serialized)
+		encodedUint8, err := d.src.next(1)
+		if err != nil {
+			return nil, fmt.Errorf("can't read uint8: %w", err)
 		}
-		return uint8(serialized[0]), serialized[1:], nil
+		return encodedUint8[0], nil
 	case reflect.Uint16:
-		if len(serialized) < 2 {
-			return nil, nil, fmt.Errorf("can't read uint16 %v", serialized)
+		encodedUint16, err := d.src.next(2)
+		if err != nil {
+			return nil, fmt.Errorf("can't read uint16: %w", err)
 		}
-		return uint16(binary.LittleEndian.Uint16(serialized[:2])), serialized[2:], nil
+		return binary.LittleEndian.Uint16(encodedUint16), nil
 	case reflect.Uint32:
-		if len(serialized) < 4 {
-			return nil, nil, fmt.Errorf("can't read uint32 %v", serialized)
+		encodedUint32, err := d.src.next(4)
+		if err != nil {
+			return nil, fmt.Errorf("can't read uint32: %w", err)
 		}
-		return uint32(binary.LittleEndian.Uint32(serialized[:4])), serialized[4:], nil
+		return binary.LittleEndian.Uint32(encodedUint32), nil
 	case reflect.Uint64:
-		if len(serialized) < 8 {
-			return nil, nil, fmt.Errorf("can't read uint64 %v", serialized)
+		encodedUint64, err := d.src.next(8)
+		if err != nil {
+			return nil, fmt.Errorf("can't read uint64: %w", err)
 		}
-		return binary.LittleEndian.Uint64(serialized[:8]), serialized[8:], nil
+		return binary.LittleEndian.Uint64(encodedUint64), nil
 	case reflect.Uintptr:
-		if len(serialized) < 8 {
-			return nil, nil, fmt.Errorf("can't read uintptr %v", serialized)
+		encodedUintptr, err := d.src.next(8)
+		if err != nil {
+			return nil, fmt.Errorf("can't read uintptr: %w", err)
 		}
-		return uintptr(binary.LittleEndian.Uint64(serialized[:8])), serialized[8:], nil
+		return uintptr(binary.LittleEndian.Uint64(encodedUintptr)), nil
 	case reflect.Float32:
-		if len(serialized) < 4 {
-			return nil, nil, fmt.Errorf("can't read float32 %v", serialized)
+		encodedFloat32, err := d.src.next(4)
+		if err != nil {
+			return nil, fmt.Errorf("can't read float32: %w", err)
 		}
-		return math.Float32frombits(binary.LittleEndian.Uint32(serialized[:4])), serialized[4:], nil
+		return math.Float32frombits(binary.LittleEndian.Uint32(encodedFloat32)), nil
 	case reflect.Float64:
-		if len(serialized) < 8 {
-			return nil, nil, fmt.Errorf("can't read float64 %v", serialized)
+		encodedFloat64, err := d.src.next(8)
+		if err != nil {
+			return nil, fmt.Errorf("can't read float64: %w", err)
 		}
-		return math.Float64frombits(binary.LittleEndian.Uint64(serialized[:8])), serialized[8:], nil
+		return math.Float64frombits(binary.LittleEndian.Uint64(encodedFloat64)), nil
 	case reflect.Complex64:
-		if len(serialized) < 8 {
-			return nil, nil, fmt.Errorf("can't read complex64 %v", serialized)
+		encodedComplex64, err := d.src.next(8)
+		if err != nil {
+			return nil, fmt.Errorf("can't read complex64: %w", err)
 		}
-		return complex(math.Float32frombits(binary.LittleEndian.Uint32(serialized[:4])), math.Float32frombits(binary.LittleEndian.Uint32(serialized[4:8]))), serialized[8:], nil
+		return complex(math.Float32frombits(binary.LittleEndian.Uint32(encodedComplex64[:4])), math.Float32frombits(binary.LittleEndian.Uint32(encodedComplex64[4:]))), nil
 	case reflect.Complex128:
-		if len(serialized) < 16 {
-			return nil, nil, fmt.Errorf("can't read complex128 %v", serialized)
+		encodedComplex128, err := d.src.next(16)
+		if err != nil {
+			return nil, fmt.Errorf("can't read complex128: %w", err)
 		}
-		return complex(math.Float64frombits(binary.LittleEndian.Uint64(serialized[:8])), math.Float64frombits(binary.LittleEndian.Uint64(serialized[8:16]))), serialized[16:], nil
+		return complex(math.Float64frombits(binary.LittleEndian.Uint64(encodedComplex128[:8])), math.Float64frombits(binary.LittleEndian.Uint64(encodedComplex128[8:]))), nil
 	case reflect.String:
-		if len(serialized) < 8 {
-			return nil, nil, fmt.Errorf("can't read string length %v", serialized)
+		length, err := d.readLength()
+		if err != nil {
+			return nil, fmt.Errorf("can't read string length: %w", err)
 		}
-		length := binary.LittleEndian.Uint64(serialized[:8])
-		serialized = serialized[8:]
-		if len(serialized) < int(length) {
-			return nil, nil, fmt.Errorf("can't read string as not enough data is present %v", serialized)
+		encodedString, err := d.src.next(length)
+		if err != nil {
+			return nil, fmt.Errorf("can't read string as not enough data is present: %w", err)
 		}
-		value := string(serialized[:length])
-		serialized = serialized[length:]
-		return value, serialized, nil
+		return string(encodedString), nil
 	case reflect.Pointer:
-		if len(serialized) < 1 {
-			return nil, nil, fmt.Errorf("can't read pointer nil status %v", serialized)
+		encodedNonNil, err := d.src.next(1)
+		if err != nil {
+			return nil, fmt.Errorf("can't read pointer nil status: %w", err)
 		}
-		nonNil := uint8(serialized[0])
-		serialized = serialized[1:]
-		if nonNil == 1 {
-			obj, serialized, err := unmarshalRecursive(serialized)
+		switch encodedNonNil[0] {
+		case pointerSet:
+			obj, err := d.unmarshalRecursive()
 			if err != nil {
-				return nil, nil, fmt.Errorf("couldn't deserialize pointer contents: %w", err)
+				return nil, fmt.Errorf("couldn't deserialize pointer contents: %w", err)
+			}
+			if obj == nil {
+				return nil, fmt.Errorf("can't deserialize pointer to untyped nil")
 			}
 			pointer := reflect.New(reflect.TypeOf(obj))
 			pointer.Elem().Set(reflect.ValueOf(obj))
-			return pointer.Interface(), serialized, nil
-		} else {
-			return nil, serialized, nil
-		}
-	case reflect.Array:
-		if len(serialized) < 8 {
-			return nil, nil, fmt.Errorf("can't read array length %v", serialized)
-		}
-		length := binary.LittleEndian.Uint64(serialized[:8])
-		serialized = serialized[8:]
-		typeMarker, serialized, err := unmarshalRecursive(serialized)
-		if err != nil {
-			return nil, nil, fmt.Errorf("couldn't deserialize array type marker: %w", err)
-		}
-		arrayPtr := reflect.New(reflect.ArrayOf(int(length), reflect.TypeOf(typeMarker)))
-		array := arrayPtr.Elem()
-		for i := 0; i < int(length); i++ {
-			var item any
-			var err error
-			item, serialized, err = unmarshalRecursive(serialized)
+			return pointer.Interface(), nil
+		case pointerTypedNil:
+			elemType, err := d.unmarshalType()
 			if err != nil {
-				return nil, nil, fmt.Errorf("couldn't deserialize array item: %w", err)
+				return nil, fmt.Errorf("couldn't deserialize nil pointer type: %w", err)
 			}
-			array.Index(i).Set(reflect.ValueOf(item))
+			return reflect.Zero(reflect.PointerTo(elemType)).Interface(), nil
+		default:
+			return nil, nil
 		}
-		return array.Interface(), serialized, nil
-	case reflect.Slice:
-		if len(serialized) < 8 {
-			return nil, nil, fmt.Errorf("can't read slice length %v", serialized)
-		}
-		length := binary.LittleEndian.Uint64(serialized[:8])
-		serialized = serialized[8:]
-		typeMarker, serialized, err := unmarshalRecursive(serialized)
+	case reflect.Array:
+		length, err := d.readLength()
 		if err != nil {
-			return nil, nil, fmt.Errorf("couldn't deserialize slice type marker: %w", err)
+			return nil, fmt.Errorf("can't read array length: %w", err)
 		}
-		slice := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(typeMarker)), int(length), int(length))
-		for i := 0; i < int(length); i++ {
-			var item any
-			var err error
-			item, serialized, err = unmarshalRecursive(serialized)
-			if err != nil {
-				return nil, nil, fmt.Errorf("couldn't deserialize slice item: %w", err)
-			}
-			slice.Index(i).Set(reflect.ValueOf(item))
-		}
-		return slice.Interface(), serialized, nil
-	case reflect.Map:
-		if len(serialized) < 8 {
-			return nil, nil, fmt.Errorf("can't read map length %v", serialized)
-		}
-		length := binary.LittleEndian.Uint64(serialized[:8])
-		serialized = serialized[8:]
-		keyTypeMarker, serialized, err := unmarshalRecursive(serialized)
+		itemType, err := d.unmarshalTypeMarker()
 		if err != nil {
-			return nil, nil, fmt.Errorf("couldn't deserialize map key type marker: %w", err)
+			return nil, fmt.Errorf("couldn't deserialize array type marker: %w", err)
 		}
-		valueTypeMarker, serialized, err := unmarshalRecursive(serialized)
+		if err := d.requireItems(length, itemType); err != nil {
+			return nil, fmt.Errorf("can't read array items: %w", err)
+		}
+		arrayType, err := arrayOf(length, itemType)
 		if err != nil {
-			return nil, nil, fmt.Errorf("couldn't deserialize map value type marker: %w", err)
+			return nil, err
 		}
-		themap := reflect.MakeMap(reflect.MapOf(reflect.TypeOf(keyTypeMarker), reflect.TypeOf(valueTypeMarker)))
+		array := reflect.New(arrayType).Elem()
 		for i := 0; i < int(length); i++ {
-			var key, itemValue any
-			var err error
-			key, serialized, err = unmarshalRecursive(serialized)
+			item, err := d.unmarshalRecursive()
 			if err != nil {
-				return nil, nil, fmt.Errorf("couldn't deserialize map key: %w", err)
+				return nil, fmt.Errorf("couldn't deserialize array item: %w", err)
 			}
-			itemValue, serialized, err = unmarshalRecursive(serialized)
-			if err != nil {
-				return nil, nil, fmt.Errorf("couldn't deserialize map value: %w", err)
+			if err := assign(array.Index(i), item, fmt.Sprintf("[%d]", i)); err != nil {
+				return nil, fmt.Errorf("couldn't deserialize array item: %w", err)
 			}
-			themap.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(itemValue))
 		}
-		return themap.Interface(), serialized, nil
+		return array.Interface(), nil
+	case reflect.Slice:
+		return d.unmarshalSlice(nil)
+	case reflect.Map:
+		return d.unmarshalMap(nil)
 	case reflect.Struct:
-		if len(serialized) < 4 {
-			return nil, nil, fmt.Errorf("can't read struct type id %v", serialized)
+		encodedTypeId, err := d.src.next(4)
+		if err != nil {
+			return nil, fmt.Errorf("can't read struct type id: %w", err)
 		}
-		if string(serialized[:4]) == "time" {
-			serialized = serialized[4:]
-			value, serialized, err := unmarshalRecursive(serialized)
-			if err != nil {
-				return nil, nil, fmt.Errorf("couldn't deserialize time as int64: %w", err)
-			}
-			if timeAsInt64, ok := value.(int64); ok {
-				return time.UnixMicro(timeAsInt64), serialized, nil
-			} else {
-				return nil, nil, fmt.Errorf("expected time as int64 but got %T", value)
-			}
+		// Older versions wrote time.Time as a struct with the id "time".
+		if string(encodedTypeId) == "time" {
+			return d.unmarshalTime()
 		} else {
-			typeId := binary.LittleEndian.Uint32(serialized[:4])
-			theType, typeKnown := idToType[typeId]
+			typeId := binary.LittleEndian.Uint32(encodedTypeId)
+			theType, typeKnown := d.codec.registry.typeById(typeId)
 			if !typeKnown {
-				return nil, nil, fmt.Errorf("can't deserialize type id %v (not registered)", typeId)
+				return nil, fmt.Errorf("can't deserialize type id %v (not registered)", typeId)
+			}
+			if theType.Kind() != reflect.Struct {
+				return nil, fmt.Errorf("can't deserialize type id %v as struct (registered as %v)", typeId, theType)
+			}
+			fields, err := cachedStructFields(theType)
+			if err != nil {
+				return nil, err
 			}
-			serialized = serialized[4:]
 			structCopy := reflect.New(theType).Elem()
-			for i := 0; i < structCopy.NumField(); i++ {
-				field := structCopy.Field(i)
-				var fieldValue any
-				var err error
-				fieldValue, serialized, err = unmarshalRecursive(serialized)
+			for _, structField := range fields.list {
+				field := structCopy.Field(structField.index)
+				fieldKind, err := d.readKind()
 				if err != nil {
-					return nil, nil, fmt.Errorf("couldn't deserialize struct field: %w", err)
+					return nil, fmt.Errorf("couldn't deserialize struct field: %w", err)
 				}
-				if fieldValue != nil {
-					unsafeField := reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem()
-					if field.Kind() == reflect.Ptr {
-						newPointer := reflect.New(field.Type().Elem())
-						unsafeField.Set(newPointer)
-						if reflect.ValueOf(fieldValue).Kind() != reflect.Ptr {
-							return nil, nil, fmt.Errorf("expected pointer but got %v", reflect.TypeOf(fieldValue))
-						}
-						if !reflect.ValueOf(fieldValue).Elem().CanConvert(field.Type().Elem()) {
-							return nil, nil, fmt.Errorf("can't convert %v to %v", reflect.TypeOf(fieldValue).Elem(), field.Type().Elem())
-						}
-						newPointer.Elem().Set(reflect.ValueOf(fieldValue).Elem().Convert(field.Type().Elem()))
-					} else {
-						if reflect.ValueOf(fieldValue).CanConvert(field.Type()) {
-							unsafeField.Set(reflect.ValueOf(fieldValue).Convert(field.Type()))
-						} else {
-							return nil, nil, fmt.Errorf("can't convert %v to %v", reflect.TypeOf(fieldValue), field.Type())
-						}
-					}
+				if fieldKind == tagZero {
+					continue
+				}
+				fieldValue, err := d.unmarshalKind(fieldKind)
+				if err != nil {
+					return nil, fmt.Errorf("couldn't deserialize struct field: %w", err)
+				}
+				unsafeField := reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem()
+				if err := assign(unsafeField, fieldValue, theType.Name()+"."+theType.Field(structField.index).Name); err != nil {
+					return nil, fmt.Errorf("couldn't deserialize struct field: %w", err)
 				}
 			}
-			return structCopy.Interface(), serialized, nil
-		}
+			return structCopy.Interface(), nil
+		}
+	case tagKeyedStruct:
+		return d.unmarshalKeyedStruct()
+	case tagNamed:
+		return d.unmarshalNamed()
+	case tagNil:
+		return d.unmarshalNil()
+	case tagTracked:
+		return d.unmarshalTracked()
+	case tagRef:
+		return d.unmarshalRef()
+	case tagWellKnown:
+		return d.unmarshalWellKnown()
+	case tagBinary, tagText, tagGoser:
+		return d.unmarshalMarshaler(kind)
+	case tagCustom:
+		return d.unmarshalCustom()
 	case reflect.Chan:
-		return nil, nil, fmt.Errorf("can't deserialize channel (%v)", kind)
+		return nil, fmt.Errorf("can't deserialize channel (%v)", kind)
 	default:
-		return nil, nil, fmt.Errorf("can't deserialize kind %v", kind)
+		return nil, fmt.Errorf("can't deserialize kind %v", kind)
+	}
+}
+
+// unmarshalSlice and unmarshalMap hand the collection to track, if given,
+// before decoding its items, so items can refer back to it.
+func (d *decodeState) unmarshalSlice(track func(reflect.Value)) (any, error) {
+	length, err := d.readLength()
+	if err != nil {
+		return nil, fmt.Errorf("can't read slice length: %w", err)
+	}
+	itemType, err := d.unmarshalTypeMarker()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't deserialize slice type marker: %w", err)
+	}
+	if err := d.requireItems(length, itemType); err != nil {
+		return nil, fmt.Errorf("can't read slice items: %w", err)
+	}
+	slice := reflect.MakeSlice(reflect.SliceOf(itemType), int(length), int(length))
+	if track != nil {
+		track(slice)
+	}
+	for i := 0; i < int(length); i++ {
+		item, err := d.unmarshalRecursive()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't deserialize slice item: %w", err)
+		}
+		if err := assign(slice.Index(i), item, fmt.Sprintf("[%d]", i)); err != nil {
+			return nil, fmt.Errorf("couldn't deserialize slice item: %w", err)
+		}
+	}
+	return slice.Interface(), nil
+}
+
+func (d *decodeState) unmarshalMap(track func(reflect.Value)) (any, error) {
+	length, err := d.readLength()
+	if err != nil {
+		return nil, fmt.Errorf("can't read map length: %w", err)
+	}
+	keyType, err := d.unmarshalTypeMarker()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't deserialize map key type marker: %w", err)
+	}
+	if !keyType.Comparable() {
+		return nil, fmt.Errorf("can't deserialize map with key type %v", keyType)
+	}
+	valueType, err := d.unmarshalTypeMarker()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't deserialize map value type marker: %w", err)
+	}
+	themap := reflect.MakeMap(reflect.MapOf(keyType, valueType))
+	if track != nil {
+		track(themap)
+	}
+	for i := 0; i < int(length); i++ {
+		key, err := d.unmarshalRecursive()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't deserialize map key: %w", err)
+		}
+		itemValue, err := d.unmarshalRecursive()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't deserialize map value: %w", err)
+		}
+		mapKey := reflect.New(keyType).Elem()
+		if err := assign(mapKey, key, fmt.Sprintf("[%v]", key)); err != nil {
+			return nil, fmt.Errorf("couldn't deserialize map key: %w", err)
+		}
+		if !hashable(mapKey) {
+			return nil, fmt.Errorf("can't deserialize map key of type %v", reflect.TypeOf(key))
+		}
+		mapValue := reflect.New(valueType).Elem()
+		if err := assign(mapValue, itemValue, fmt.Sprintf("[%v]", key)); err != nil {
+			return nil, fmt.Errorf("couldn't deserialize map value: %w", err)
+		}
+		themap.SetMapIndex(mapKey, mapValue)
+	}
+	return themap.Interface(), nil
+}
+
+// hashable tells whether value can be used as a map key. Keys of a
+// comparable type can still hold an uncomparable value in an interface.
+func hashable(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Interface:
+		return value.IsNil() || hashable(value.Elem())
+	case reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if !hashable(value.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if !hashable(value.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return value.Type().Comparable()
+}
+
+// Type markers describe the item types of arrays, slices and maps. They are
+// the marshalled zero value of the type, except for interfaces, whose zero
+// value is nil and says nothing about the type, so they get a tag of their
+// own and every item carries its dynamic type instead.
+func (e *encodeState) marshalTypeMarker(theType reflect.Type) ([]byte, error) {
+	if theType.Kind() == reflect.Interface {
+		return []byte{byte(tagInterface)}, nil
+	}
+	return e.marshal(reflect.Zero(theType).Interface())
+}
+
+var interfaceType = reflect.TypeOf((*any)(nil)).Elem()
+
+func (d *decodeState) unmarshalTypeMarker() (reflect.Type, error) {
+	kind, err := d.readKind()
+	if err != nil {
+		return nil, err
+	}
+	if kind == tagInterface {
+		return interfaceType, nil
+	}
+	typeMarker, err := d.unmarshalKind(kind)
+	if err != nil {
+		return nil, err
+	}
+	if typeMarker == nil {
+		return nil, fmt.Errorf("type marker has no type")
+	}
+	return reflect.TypeOf(typeMarker), nil
+}
+
+// requireItems checks that the input holds enough bytes for length items of
+// itemType before they are allocated, as the length comes from the input.
+func (d *decodeState) requireItems(length uint64, itemType reflect.Type) error {
+	itemSize := d.minimumEncodedSize(itemType)
+	if length > 0 && itemSize > math.MaxUint64/length {
+		return fmt.Errorf("can't allocate %v items of %v", length, itemType)
+	}
+	return d.src.require(length * itemSize)
+}
+
+// minimumEncodedSize is a lower bound on the bytes a value of theType takes
+// up in the input. Every value has at least its kind, and arrays are written
+// item by item unless a registered type encodes them its own way.
+func (d *decodeState) minimumEncodedSize(theType reflect.Type) uint64 {
+	if theType.Kind() != reflect.Array || theType.Len() == 0 {
+		return 1
+	}
+	if _, _, typeKnown := d.codec.registry.lookup(theType); typeKnown {
+		return 1
+	}
+	length := uint64(theType.Len())
+	itemSize := d.minimumEncodedSize(theType.Elem())
+	if itemSize > math.MaxUint64/length {
+		return math.MaxUint64
+	}
+	return length * itemSize
+}
+
+// arrayOf is reflect.ArrayOf for a length read from the input, which panics
+// when the array can't fit in memory.
+func arrayOf(length uint64, itemType reflect.Type) (reflect.Type, error) {
+	itemSize := uint64(itemType.Size())
+	if length > math.MaxInt || (itemSize > 0 && length > math.MaxInt/itemSize) {
+		return nil, fmt.Errorf("can't deserialize array of %v items of %v", length, itemType)
+	}
+	return reflect.ArrayOf(int(length), itemType), nil
+}
+
+func appendLength(serialized []byte, length int) []byte {
+	encodedLength := make([]byte, 8)
+	binary.LittleEndian.PutUint64(encodedLength, uint64(length))
+	return append(serialized, encodedLength...)
+}
+
+func readLength(src source) (uint64, error) {
+	encodedLength, err := src.next(8)
+	if err != nil {
+		return 0, err
 	}
+	return binary.LittleEndian.Uint64(encodedLength), nil
 }
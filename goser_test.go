@@ -238,15 +238,15 @@ func TestTime(t *testing.T) {
 
 func TestRegisterUnnamed(t *testing.T) {
 	Register(struct{}{})
-	if idToType[0x5c18d754] == nil {
+	if theType, _ := defaultCodec.registry.typeById(0x5c18d754); theType == nil {
 		t.Errorf("empty struct not registered")
 	}
 	Register(&struct{}{})
-	if idToType[0xecee41fc] == nil {
+	if theType, _ := defaultCodec.registry.typeById(0xecee41fc); theType == nil {
 		t.Errorf("empty struct pointer not registered")
 	}
 	Register("")
-	if idToType[0x17c16538] == nil {
+	if theType, _ := defaultCodec.registry.typeById(0x17c16538); theType == nil {
 		t.Errorf("empty string not registered")
 	}
 }
@@ -397,3 +397,122 @@ func TestStructWithInvalidField(t *testing.T) {
 		t.Error(fmt.Errorf("no error raised for struct with invalid field"))
 	}
 }
+
+func TestInterfaceSlice(t *testing.T) {
+	type Payload struct {
+		id int
+	}
+	Register(Payload{})
+	values := []any{1, "two", nil, []any{3.0, true}, Payload{id: 4}, &Payload{id: 5}}
+	bytes, err := Marshal(values)
+	if err != nil {
+		t.Error(err)
+	}
+	decoded, err := Unmarshal(bytes)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(decoded, values) {
+		t.Error(fmt.Errorf("before and after for []any is not the same: %#v", decoded))
+	}
+}
+
+func TestInterfaceMap(t *testing.T) {
+	event := map[string]any{
+		"user":   "ana",
+		"count":  int64(3),
+		"tags":   []string{"a"},
+		"nested": map[string]any{"ok": true, "missing": nil},
+	}
+	bytes, err := Marshal(event)
+	if err != nil {
+		t.Error(err)
+	}
+	decoded, err := Unmarshal(bytes)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(decoded, event) {
+		t.Error(fmt.Errorf("before and after for map[string]any is not the same: %#v", decoded))
+	}
+	keyed := map[any]any{1: "int key", "s": 2}
+	bytes, err = Marshal(keyed)
+	if err != nil {
+		t.Error(err)
+	}
+	decoded, err = Unmarshal(bytes)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(decoded, keyed) {
+		t.Error(fmt.Errorf("before and after for map[any]any is not the same: %#v", decoded))
+	}
+}
+
+func TestInterfaceArray(t *testing.T) {
+	values := [3]any{int8(1), nil, "x"}
+	bytes, err := Marshal(values)
+	if err != nil {
+		t.Error(err)
+	}
+	decoded, err := Unmarshal(bytes)
+	if err != nil {
+		t.Error(err)
+	}
+	if decoded != values {
+		t.Error(fmt.Errorf("before and after for [3]any is not the same: %#v", decoded))
+	}
+}
+
+func TestUntypedTypeMarker(t *testing.T) {
+	bytes := []byte{byte(reflect.Slice), 0, 0, 0, 0, 0, 0, 0, 0, byte(reflect.Pointer), 0}
+	_, err := Unmarshal(bytes)
+	if err == nil {
+		t.Error(fmt.Errorf("no error raised for slice without item type"))
+	}
+}
+
+func TestStructMarkerForNamedType(t *testing.T) {
+	type Status int
+	type Tags []string
+	codec := NewCodec()
+	codec.RegisterWithID(Status(0), 1)
+	codec.RegisterWithID(Tags{}, 2)
+	payloads := map[string][]byte{
+		"named int":         {byte(reflect.Struct), 1, 0, 0, 0},
+		"named slice":       {byte(reflect.Struct), 2, 0, 0, 0},
+		"keyed named int":   {byte(tagKeyedStruct), 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		"keyed named slice": {byte(tagKeyedStruct), 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	}
+	for name, payload := range payloads {
+		if _, err := codec.Unmarshal(payload); err == nil {
+			t.Error(fmt.Errorf("no error raised for %v as struct", name))
+		}
+	}
+}
+
+func TestUnhashableMapKey(t *testing.T) {
+	bytes := []byte{byte(reflect.Map), 1, 0, 0, 0, 0, 0, 0, 0, byte(tagInterface), byte(tagInterface)}
+	bytes = append(bytes, mustMarshal(t, []int{1})...)
+	bytes = append(bytes, mustMarshal(t, 1)...)
+	_, err := Unmarshal(bytes)
+	if err == nil {
+		t.Error(fmt.Errorf("no error raised for slice as map key"))
+	}
+	bytes = []byte{byte(reflect.Map), 1, 0, 0, 0, 0, 0, 0, 0, byte(reflect.Array), 1, 0, 0, 0, 0, 0, 0, 0, byte(tagInterface), byte(tagInterface)}
+	bytes = append(bytes, byte(reflect.Array), 1, 0, 0, 0, 0, 0, 0, 0, byte(tagInterface))
+	bytes = append(bytes, mustMarshal(t, map[int]int{})...)
+	bytes = append(bytes, mustMarshal(t, 1)...)
+	_, err = Unmarshal(bytes)
+	if err == nil {
+		t.Error(fmt.Errorf("no error raised for map inside array map key"))
+	}
+}
+
+func TestMismatchedSliceItem(t *testing.T) {
+	bytes := []byte{byte(reflect.Slice), 1, 0, 0, 0, 0, 0, 0, 0, byte(reflect.Bool), 0, byte(reflect.Int8), 1}
+	_, err := Unmarshal(bytes)
+	if err == nil {
+		t.Error(fmt.Errorf("no error raised for slice item of the wrong type"))
+	}
+}
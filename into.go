@@ -0,0 +1,166 @@
+package goser
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// UnmarshalTypeError is returned when a decoded value can't be stored in the
+// Go value it is being decoded into.
+type UnmarshalTypeError struct {
+	Path string
+	Got  reflect.Type
+	Want reflect.Type
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	got := "nil"
+	if e.Got != nil {
+		got = e.Got.String()
+	}
+	if e.Path == "" {
+		return fmt.Sprintf("can't store %v in %v", got, e.Want)
+	}
+	return fmt.Sprintf("can't store %v in %v (at %v)", got, e.Want, e.Path)
+}
+
+// UnmarshalInto decodes serialized like Unmarshal does and stores the result
+// in the value pointed to by target. The decoding itself doesn't look at the
+// target, so structs still need their type id registered, and the result is
+// copied into the target afterwards: named types are converted to from their
+// underlying kind and pointers are followed or allocated as needed, anything
+// that doesn't fit is reported as an *UnmarshalTypeError.
+func UnmarshalInto(serialized []byte, target any) error {
+	return defaultCodec.UnmarshalInto(serialized, target)
+}
+
+func intoTarget(target any) (reflect.Value, error) {
+	targetValue := reflect.ValueOf(target)
+	if targetValue.Kind() != reflect.Pointer || targetValue.IsNil() {
+		return reflect.Value{}, fmt.Errorf("can't unmarshal into %v (not a non-nil pointer)", reflect.TypeOf(target))
+	}
+	return targetValue.Elem(), nil
+}
+
+func assign(target reflect.Value, value any, path string) error {
+	if value == nil {
+		switch target.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map:
+			target.Set(reflect.Zero(target.Type()))
+			return nil
+		}
+		return &UnmarshalTypeError{Path: path, Want: target.Type()}
+	}
+	return assignValue(target, reflect.ValueOf(value), path)
+}
+
+func assignValue(target reflect.Value, source reflect.Value, path string) error {
+	if source.Kind() == reflect.Interface {
+		if source.IsNil() {
+			return assign(target, nil, path)
+		}
+		source = source.Elem()
+	}
+	targetType := target.Type()
+	if source.Type() == targetType {
+		target.Set(source)
+		return nil
+	}
+	mismatch := func() error {
+		return &UnmarshalTypeError{Path: path, Got: source.Type(), Want: targetType}
+	}
+	if target.Kind() == reflect.Interface {
+		if !source.Type().Implements(targetType) {
+			return mismatch()
+		}
+		target.Set(source)
+		return nil
+	}
+	if source.Kind() == reflect.Pointer && target.Kind() != reflect.Pointer {
+		if source.IsNil() {
+			return mismatch()
+		}
+		return assignValue(target, source.Elem(), path)
+	}
+
+	switch target.Kind() {
+	case reflect.Pointer:
+		if source.Kind() == reflect.Pointer {
+			if source.IsNil() {
+				target.Set(reflect.Zero(targetType))
+				return nil
+			}
+			source = source.Elem()
+		}
+		pointer := reflect.New(targetType.Elem())
+		if err := assignValue(pointer.Elem(), source, path); err != nil {
+			return err
+		}
+		target.Set(pointer)
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128, reflect.String:
+		if source.Kind() != target.Kind() {
+			return mismatch()
+		}
+		target.Set(source.Convert(targetType))
+	case reflect.Array:
+		if source.Kind() != reflect.Array && source.Kind() != reflect.Slice {
+			return mismatch()
+		}
+		if source.Len() != target.Len() {
+			return fmt.Errorf("can't store %v items in %v (at %v)", source.Len(), targetType, path)
+		}
+		for i := 0; i < source.Len(); i++ {
+			if err := assignValue(target.Index(i), source.Index(i), fmt.Sprintf("%v[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if source.Kind() != reflect.Array && source.Kind() != reflect.Slice {
+			return mismatch()
+		}
+		if source.Kind() == reflect.Slice && source.IsNil() {
+			target.Set(reflect.Zero(targetType))
+			return nil
+		}
+		slice := reflect.MakeSlice(targetType, source.Len(), source.Len())
+		for i := 0; i < source.Len(); i++ {
+			if err := assignValue(slice.Index(i), source.Index(i), fmt.Sprintf("%v[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		target.Set(slice)
+	case reflect.Map:
+		if source.Kind() != reflect.Map {
+			return mismatch()
+		}
+		if source.IsNil() {
+			target.Set(reflect.Zero(targetType))
+			return nil
+		}
+		themap := reflect.MakeMapWithSize(targetType, source.Len())
+		mapRange := source.MapRange()
+		for mapRange.Next() {
+			itemPath := fmt.Sprintf("%v[%v]", path, mapRange.Key())
+			key := reflect.New(targetType.Key()).Elem()
+			if err := assignValue(key, mapRange.Key(), itemPath); err != nil {
+				return err
+			}
+			item := reflect.New(targetType.Elem()).Elem()
+			if err := assignValue(item, mapRange.Value(), itemPath); err != nil {
+				return err
+			}
+			themap.SetMapIndex(key, item)
+		}
+		target.Set(themap)
+	case reflect.Struct:
+		if !source.Type().ConvertibleTo(targetType) {
+			return mismatch()
+		}
+		target.Set(source.Convert(targetType))
+	default:
+		return mismatch()
+	}
+	return nil
+}
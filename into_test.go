@@ -0,0 +1,100 @@
+package goser
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestUnmarshalIntoStruct(t *testing.T) {
+	type IntoContainer struct {
+		name  string
+		ptr   *int
+		nilly *int
+		tags  []string
+	}
+	Register(IntoContainer{})
+	seven := 7
+	bytes, err := Marshal(&IntoContainer{name: "x", ptr: &seven, tags: []string{"a", "b"}})
+	if err != nil {
+		t.Error(err)
+	}
+	var decoded IntoContainer
+	err = UnmarshalInto(bytes, &decoded)
+	if err != nil {
+		t.Error(err)
+	}
+	if decoded.name != "x" || *decoded.ptr != 7 || decoded.nilly != nil || len(decoded.tags) != 2 {
+		t.Error(fmt.Errorf("before and after for struct is not the same: %#v", decoded))
+	}
+	var decodedPtr *IntoContainer
+	err = UnmarshalInto(bytes, &decodedPtr)
+	if err != nil {
+		t.Error(err)
+	}
+	if decodedPtr == nil || decodedPtr.name != "x" {
+		t.Error(fmt.Errorf("before and after for struct pointer is not the same"))
+	}
+}
+
+func TestUnmarshalIntoNamed(t *testing.T) {
+	type Level int16
+	type Levels map[string][]Level
+	bytes, err := Marshal(map[string][]int16{"a": {1, -2}})
+	if err != nil {
+		t.Error(err)
+	}
+	var levels Levels
+	err = UnmarshalInto(bytes, &levels)
+	if err != nil {
+		t.Error(err)
+	}
+	if levels["a"][1] != Level(-2) {
+		t.Error(fmt.Errorf("before and after for named map is not the same"))
+	}
+}
+
+func TestUnmarshalIntoInt8(t *testing.T) {
+	bytes, err := Marshal(int8(-5))
+	if err != nil {
+		t.Error(err)
+	}
+	var anyValue any
+	err = UnmarshalInto(bytes, &anyValue)
+	if err != nil {
+		t.Error(err)
+	}
+	if anyValue != int8(-5) {
+		t.Error(fmt.Errorf("before and after for int8 is not the same: %#v", anyValue))
+	}
+}
+
+func TestUnmarshalIntoMismatch(t *testing.T) {
+	bytes, err := Marshal([]string{"a"})
+	if err != nil {
+		t.Error(err)
+	}
+	var ints []int
+	err = UnmarshalInto(bytes, &ints)
+	var typeErr *UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		t.Fatal(fmt.Errorf("expected *UnmarshalTypeError, got %v", err))
+	}
+	if typeErr.Path != "[0]" {
+		t.Error(fmt.Errorf("unexpected mismatch path %q", typeErr.Path))
+	}
+}
+
+func TestUnmarshalIntoNonPointer(t *testing.T) {
+	bytes, err := Marshal(1)
+	if err != nil {
+		t.Error(err)
+	}
+	var value int
+	if UnmarshalInto(bytes, value) == nil {
+		t.Error(fmt.Errorf("no error raised"))
+	}
+	if UnmarshalInto(bytes, (*int)(nil)) == nil {
+		t.Error(fmt.Errorf("no error raised"))
+	}
+}
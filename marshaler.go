@@ -0,0 +1,157 @@
+package goser
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Marshaler is implemented by registered types that write themselves with
+// an Encoder, e.g. to keep invariants their fields alone don't capture. The
+// values it encodes are nested in the type's own payload and don't share
+// references with the rest of the data.
+type Marshaler interface {
+	MarshalGoser(enc *Encoder) error
+}
+
+// Unmarshaler is implemented by pointers to registered types that read
+// themselves back with a Decoder. UnmarshalGoser is called on a fresh value
+// and has to decode everything MarshalGoser encoded.
+type Unmarshaler interface {
+	UnmarshalGoser(dec *Decoder) error
+}
+
+var (
+	marshalerType         = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType       = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	textMarshalerType     = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// marshalerTag tells whether values of theType encode themselves: tagGoser
+// when a pointer to the type implements Marshaler and Unmarshaler, tagBinary
+// and tagText for the encoding.BinaryMarshaler and encoding.TextMarshaler
+// pairs, and 0 when it implements none of them. Methods promoted from an
+// embedded field don't count, as they would only encode that field.
+func marshalerTag(theType reflect.Type) reflect.Kind {
+	if tag, ok := marshalerTagCache.Load(theType); ok {
+		return tag.(reflect.Kind)
+	}
+	tag := reflect.Kind(0)
+	pointerType := reflect.PointerTo(theType)
+	switch {
+	case pointerType.Implements(marshalerType) && pointerType.Implements(unmarshalerType) &&
+		declaresMethods(theType, "MarshalGoser", "UnmarshalGoser"):
+		tag = tagGoser
+	case pointerType.Implements(binaryMarshalerType) && pointerType.Implements(binaryUnmarshalerType) &&
+		declaresMethods(theType, "MarshalBinary", "UnmarshalBinary"):
+		tag = tagBinary
+	case pointerType.Implements(textMarshalerType) && pointerType.Implements(textUnmarshalerType) &&
+		declaresMethods(theType, "MarshalText", "UnmarshalText"):
+		tag = tagText
+	}
+	marshalerTagCache.Store(theType, tag)
+	return tag
+}
+
+var marshalerTagCache sync.Map
+
+// declaresMethods tells whether none of the named methods reach theType
+// through an embedded field. A struct that embeds a type with the methods
+// and also declares its own is treated as promoting them.
+func declaresMethods(theType reflect.Type, names ...string) bool {
+	if theType.Kind() != reflect.Struct {
+		return true
+	}
+	for i := 0; i < theType.NumField(); i++ {
+		field := theType.Field(i)
+		if !field.Anonymous {
+			continue
+		}
+		for _, name := range names {
+			if _, promoted := field.Type.MethodByName(name); promoted {
+				return false
+			}
+			if field.Type.Kind() != reflect.Pointer && field.Type.Kind() != reflect.Interface {
+				if _, promoted := reflect.PointerTo(field.Type).MethodByName(name); promoted {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// Registered types with their own encoding are written as tagGoser,
+// tagBinary or tagText and their type id, followed by the length-prefixed
+// payload they produced. Only the type itself knows what is in it.
+func (e *encodeState) marshalMarshaler(tag reflect.Kind, typeId uint32, value reflect.Value) ([]byte, error) {
+	addressable := reflect.New(value.Type())
+	addressable.Elem().Set(value)
+	var payload []byte
+	var err error
+	switch tag {
+	case tagGoser:
+		var buffer bytes.Buffer
+		err = addressable.Interface().(Marshaler).MarshalGoser(&Encoder{codec: e.codec, writer: &buffer})
+		payload = buffer.Bytes()
+	case tagBinary:
+		payload, err = addressable.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+	default:
+		payload, err = addressable.Interface().(encoding.TextMarshaler).MarshalText()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't serialize %v: %w", value.Type(), err)
+	}
+	serialized := []byte{byte(tag)}
+	encodedTypeId := make([]byte, 4)
+	binary.LittleEndian.PutUint32(encodedTypeId, typeId)
+	serialized = append(serialized, encodedTypeId...)
+	serialized = e.appendLength(serialized, len(payload))
+	return append(serialized, payload...), nil
+}
+
+func (d *decodeState) unmarshalMarshaler(tag reflect.Kind) (any, error) {
+	encodedTypeId, err := d.src.next(4)
+	if err != nil {
+		return nil, fmt.Errorf("can't read type id: %w", err)
+	}
+	typeId := binary.LittleEndian.Uint32(encodedTypeId)
+	theType, typeKnown := d.codec.registry.typeById(typeId)
+	if !typeKnown {
+		return nil, fmt.Errorf("can't deserialize type id %v (not registered)", typeId)
+	}
+	length, err := d.readLength()
+	if err != nil {
+		return nil, fmt.Errorf("can't read %v length: %w", theType, err)
+	}
+	payload, err := d.src.next(length)
+	if err != nil {
+		return nil, fmt.Errorf("can't read %v: %w", theType, err)
+	}
+	if marshalerTag(theType) != tag {
+		return nil, fmt.Errorf("can't deserialize %v (doesn't unmarshal the payload itself)", theType)
+	}
+	fresh := reflect.New(theType)
+	switch tag {
+	case tagGoser:
+		src := &sliceSource{data: payload}
+		err = fresh.Interface().(Unmarshaler).UnmarshalGoser(&Decoder{codec: d.codec, src: src})
+		if err == nil && len(src.data) > 0 {
+			err = fmt.Errorf("couldn't consume all the provided bytes")
+		}
+	case tagBinary:
+		err = fresh.Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(payload)
+	default:
+		err = fresh.Interface().(encoding.TextUnmarshaler).UnmarshalText(payload)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't deserialize %v: %w", theType, err)
+	}
+	return fresh.Elem().Interface(), nil
+}
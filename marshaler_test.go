@@ -0,0 +1,246 @@
+package goser
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type semver struct {
+	major, minor uint16
+	parsed       bool
+}
+
+func (v semver) MarshalBinary() ([]byte, error) {
+	return []byte{byte(v.major), byte(v.minor)}, nil
+}
+
+func (v *semver) UnmarshalBinary(data []byte) error {
+	if len(data) != 2 {
+		return errors.New("semver needs 2 bytes")
+	}
+	*v = semver{major: uint16(data[0]), minor: uint16(data[1]), parsed: true}
+	return nil
+}
+
+type colour string
+
+func (c *colour) MarshalText() ([]byte, error) {
+	return []byte(strings.ToUpper(string(*c))), nil
+}
+
+func (c *colour) UnmarshalText(text []byte) error {
+	*c = colour(strings.ToLower(string(text)))
+	return nil
+}
+
+func TestBinaryMarshaler(t *testing.T) {
+	codec := NewCodec()
+	codec.MustRegister(semver{})
+	serialized, err := codec.Marshal(semver{major: 1, minor: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serialized[0] != byte(tagBinary) || len(serialized) != 1+4+8+2 {
+		t.Error(fmt.Errorf("semver not written through MarshalBinary: % x", serialized))
+	}
+	decoded, err := codec.Unmarshal(serialized)
+	if err != nil {
+		t.Error(err)
+	}
+	if decoded != (semver{major: 1, minor: 2, parsed: true}) {
+		t.Error(fmt.Errorf("semver decoded as %#v", decoded))
+	}
+}
+
+func TestTextMarshaler(t *testing.T) {
+	codec := NewCodec()
+	codec.MustRegister(colour(""))
+	original := []any{colour("red"), &[]colour{"blue"}}
+	serialized, err := codec.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(serialized), "RED") {
+		t.Error(fmt.Errorf("colour not written through MarshalText: % x", serialized))
+	}
+	decoded, err := codec.Unmarshal(serialized)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Error(fmt.Errorf("before and after for colours is not the same: %#v", decoded))
+	}
+}
+
+func TestBinaryMarshalerInStruct(t *testing.T) {
+	type Peer struct {
+		Version semver
+		Address netip.Addr
+	}
+	codec := NewCodec()
+	codec.MustRegister(Peer{})
+	codec.MustRegister(semver{})
+	codec.MustRegister(netip.Addr{})
+	original := Peer{Version: semver{major: 3, parsed: true}, Address: netip.MustParseAddr("fe80::1%eth0")}
+	serialized, err := codec.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Peer
+	if err := codec.UnmarshalInto(serialized, &decoded); err != nil {
+		t.Error(err)
+	}
+	if decoded != original {
+		t.Error(fmt.Errorf("before and after for Peer is not the same: %#v", decoded))
+	}
+}
+
+func TestBinaryMarshalerUnregistered(t *testing.T) {
+	if _, err := NewCodec().Marshal(semver{major: 1}); err == nil {
+		t.Error(fmt.Errorf("no error raised for unregistered semver"))
+	}
+}
+
+func TestBinaryMarshalerInvalidPayload(t *testing.T) {
+	codec := NewCodec()
+	codec.MustRegister(semver{})
+	serialized, err := codec.Marshal(semver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialized[5] = 1
+	if _, err := codec.Unmarshal(serialized[:len(serialized)-1]); err == nil {
+		t.Error(fmt.Errorf("no error raised for short payload"))
+	}
+}
+
+type intSet struct {
+	items []int
+	index map[int]bool
+}
+
+func newIntSet(items ...int) intSet {
+	set := intSet{index: map[int]bool{}}
+	for _, item := range items {
+		if !set.index[item] {
+			set.items = append(set.items, item)
+			set.index[item] = true
+		}
+	}
+	return set
+}
+
+func (s intSet) MarshalGoser(enc *Encoder) error {
+	return enc.Encode(s.items)
+}
+
+func (s *intSet) UnmarshalGoser(dec *Decoder) error {
+	var items []int
+	if err := dec.DecodeInto(&items); err != nil {
+		return err
+	}
+	*s = newIntSet(items...)
+	return nil
+}
+
+func TestGoserMarshaler(t *testing.T) {
+	type Tagged struct {
+		Name string
+		Ids  intSet
+	}
+	codec := NewCodec()
+	codec.MustRegister(Tagged{})
+	codec.MustRegister(intSet{})
+	original := Tagged{Name: "x", Ids: newIntSet(3, 1, 3, 2)}
+	serialized, err := codec.Marshal(&original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded *Tagged
+	if err := codec.UnmarshalInto(serialized, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*decoded, original) {
+		t.Error(fmt.Errorf("before and after for Tagged is not the same: %#v", decoded))
+	}
+}
+
+func TestGoserMarshalerPreferred(t *testing.T) {
+	if marshalerTag(reflect.TypeOf(intSet{})) != tagGoser || marshalerTag(reflect.TypeOf(semver{})) != tagBinary {
+		t.Error(fmt.Errorf("unexpected marshaler tags"))
+	}
+}
+
+type leftovers struct{}
+
+func (leftovers) MarshalGoser(enc *Encoder) error {
+	if err := enc.Encode(1); err != nil {
+		return err
+	}
+	return enc.Encode(2)
+}
+
+func (*leftovers) UnmarshalGoser(dec *Decoder) error {
+	_, err := dec.Decode()
+	return err
+}
+
+func TestGoserMarshalerLeftovers(t *testing.T) {
+	codec := NewCodec()
+	codec.MustRegister(leftovers{})
+	serialized, err := codec.Marshal(leftovers{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codec.Unmarshal(serialized); err == nil {
+		t.Error(fmt.Errorf("no error raised for unconsumed payload"))
+	}
+}
+
+func TestMarshalerPromotedMethods(t *testing.T) {
+	type Event struct {
+		time.Time
+		Name string
+	}
+	type Stamped struct {
+		*semver
+		Label string
+	}
+	codec := NewCodec()
+	codec.MustRegister(Event{})
+	codec.MustRegister(Stamped{})
+	codec.MustRegister(semver{})
+	original := Event{Time: time.Date(2023, 4, 5, 6, 7, 8, 9, time.UTC), Name: "boot"}
+	var decoded Event
+	if err := codec.UnmarshalInto(mustMarshalWith(t, codec, original), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Name != "boot" || !decoded.Time.Equal(original.Time) {
+		t.Error(fmt.Errorf("before and after for Event is not the same: %#v", decoded))
+	}
+	stamped := Stamped{semver: &semver{major: 2, parsed: true}, Label: "v2"}
+	var decodedStamped Stamped
+	if err := codec.UnmarshalInto(mustMarshalWith(t, codec, stamped), &decodedStamped); err != nil {
+		t.Fatal(err)
+	}
+	if decodedStamped.Label != "v2" || *decodedStamped.semver != *stamped.semver {
+		t.Error(fmt.Errorf("before and after for Stamped is not the same: %#v", decodedStamped))
+	}
+	if marshalerTag(reflect.TypeOf(Event{})) != 0 || marshalerTag(reflect.TypeOf(semver{})) != tagBinary {
+		t.Error(fmt.Errorf("unexpected marshaler tags"))
+	}
+}
+
+func mustMarshalWith(t *testing.T, codec *Codec, obj any) []byte {
+	t.Helper()
+	serialized, err := codec.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serialized
+}
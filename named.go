@@ -0,0 +1,82 @@
+package goser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+)
+
+// Registered named types that aren't structs, such as `type Status int`, are
+// written as tagNamed and their type id followed by the value converted to
+// its underlying type, so they unmarshal to the named type again. Types
+// that aren't registered are written by kind alone.
+func (e *encodeState) marshalNamed(typeId uint32, value reflect.Value) ([]byte, error) {
+	underlying := underlyingType(value.Type())
+	if underlying == nil {
+		return nil, fmt.Errorf("can't serialize named type %v of kind %v", value.Type(), value.Kind())
+	}
+	serialized := []byte{byte(tagNamed)}
+	encodedTypeId := make([]byte, 4)
+	binary.LittleEndian.PutUint32(encodedTypeId, typeId)
+	serialized = append(serialized, encodedTypeId...)
+	encodedValue, err := e.marshal(value.Convert(underlying).Interface())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't serialize %v: %w", value.Type(), err)
+	}
+	return append(serialized, encodedValue...), nil
+}
+
+func (d *decodeState) unmarshalNamed() (any, error) {
+	encodedTypeId, err := d.src.next(4)
+	if err != nil {
+		return nil, fmt.Errorf("can't read named type id: %w", err)
+	}
+	typeId := binary.LittleEndian.Uint32(encodedTypeId)
+	theType, typeKnown := d.codec.registry.typeById(typeId)
+	if !typeKnown {
+		return nil, fmt.Errorf("can't deserialize type id %v (not registered)", typeId)
+	}
+	value, err := d.unmarshalRecursive()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't deserialize %v: %w", theType, err)
+	}
+	underlying := underlyingType(theType)
+	if underlying == nil || reflect.TypeOf(value) != underlying {
+		return nil, &UnmarshalTypeError{Got: reflect.TypeOf(value), Want: theType}
+	}
+	return reflect.ValueOf(value).Convert(theType).Interface(), nil
+}
+
+var kindTypes = map[reflect.Kind]reflect.Type{
+	reflect.Bool:       reflect.TypeOf(false),
+	reflect.Int:        reflect.TypeOf(int(0)),
+	reflect.Int8:       reflect.TypeOf(int8(0)),
+	reflect.Int16:      reflect.TypeOf(int16(0)),
+	reflect.Int32:      reflect.TypeOf(int32(0)),
+	reflect.Int64:      reflect.TypeOf(int64(0)),
+	reflect.Uint:       reflect.TypeOf(uint(0)),
+	reflect.Uint8:      reflect.TypeOf(uint8(0)),
+	reflect.Uint16:     reflect.TypeOf(uint16(0)),
+	reflect.Uint32:     reflect.TypeOf(uint32(0)),
+	reflect.Uint64:     reflect.TypeOf(uint64(0)),
+	reflect.Uintptr:    reflect.TypeOf(uintptr(0)),
+	reflect.Float32:    reflect.TypeOf(float32(0)),
+	reflect.Float64:    reflect.TypeOf(float64(0)),
+	reflect.Complex64:  reflect.TypeOf(complex64(0)),
+	reflect.Complex128: reflect.TypeOf(complex128(0)),
+	reflect.String:     reflect.TypeOf(""),
+}
+
+// underlyingType returns the unnamed type a named type is defined over, or
+// nil for kinds a named type is never written as.
+func underlyingType(theType reflect.Type) reflect.Type {
+	switch theType.Kind() {
+	case reflect.Array:
+		return reflect.ArrayOf(theType.Len(), theType.Elem())
+	case reflect.Slice:
+		return reflect.SliceOf(theType.Elem())
+	case reflect.Map:
+		return reflect.MapOf(theType.Key(), theType.Elem())
+	}
+	return kindTypes[theType.Kind()]
+}
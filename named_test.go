@@ -0,0 +1,79 @@
+package goser
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type Status int
+
+func (s Status) String() string {
+	return fmt.Sprintf("status-%d", int(s))
+}
+
+func TestNamedScalar(t *testing.T) {
+	codec := NewCodec()
+	codec.MustRegister(Status(0))
+	serialized, err := codec.Marshal(Status(3))
+	if err != nil {
+		t.Error(err)
+	}
+	decoded, err := codec.Unmarshal(serialized)
+	if err != nil {
+		t.Error(err)
+	}
+	if decoded != Status(3) {
+		t.Error(fmt.Errorf("before and after for Status is not the same: %#v", decoded))
+	}
+	if stringer, ok := decoded.(fmt.Stringer); !ok || stringer.String() != "status-3" {
+		t.Error(fmt.Errorf("methods of Status were lost"))
+	}
+}
+
+func TestNamedCollections(t *testing.T) {
+	type Tags []string
+	type Scores map[string]Status
+	type Pair [2]Status
+	codec := NewCodec()
+	codec.MustRegister(Status(0))
+	codec.MustRegister(Tags{})
+	codec.MustRegister(Scores{})
+	codec.MustRegister(Pair{})
+	values := []any{Tags{"a", "b"}, Scores{"x": 1}, Pair{2, 3}, []Status{4}, Status(5)}
+	serialized, err := codec.Marshal(values)
+	if err != nil {
+		t.Error(err)
+	}
+	decoded, err := codec.Unmarshal(serialized)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(decoded, values) {
+		t.Error(fmt.Errorf("before and after for named collections is not the same: %#v", decoded))
+	}
+}
+
+func TestUnregisteredNamed(t *testing.T) {
+	type Unregistered int
+	decoded, err := Unmarshal(mustMarshal(t, Unregistered(1)))
+	if err != nil {
+		t.Error(err)
+	}
+	if decoded != 1 {
+		t.Error(fmt.Errorf("expected unregistered named type as int, got %#v", decoded))
+	}
+}
+
+func TestNamedWrongUnderlying(t *testing.T) {
+	codec := NewCodec()
+	codec.MustRegister(Status(0))
+	serialized, err := codec.Marshal(Status(1))
+	if err != nil {
+		t.Error(err)
+	}
+	serialized = append(serialized[:5], mustMarshal(t, "one")...)
+	if _, err := codec.Unmarshal(serialized); err == nil {
+		t.Error(fmt.Errorf("no error raised for named type with the wrong underlying value"))
+	}
+}
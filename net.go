@@ -0,0 +1,85 @@
+package goser
+
+import (
+	"encoding"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"reflect"
+)
+
+// The networking types are written through their own binary forms where
+// they have one (the netip types and url.URL), which keeps e.g. the zone of
+// a netip.Addr that its fields only hold as an interned pointer. net.IP
+// keeps its length, so an IPv4 address in 16-byte form stays that way, and
+// a nil IP stays nil.
+
+func (e *encodeState) appendBinary(value encoding.BinaryMarshaler) ([]byte, error) {
+	payload, err := value.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append(e.appendLength(nil, len(payload)), payload...), nil
+}
+
+// unmarshalBinaryInto returns a function decoding a length-prefixed payload
+// into a fresh value of T.
+func unmarshalBinaryInto[T any, P interface {
+	*T
+	encoding.BinaryUnmarshaler
+}]() func(d *decodeState) (any, error) {
+	return func(d *decodeState) (any, error) {
+		payload, err := d.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		var value T
+		if err := P(&value).UnmarshalBinary(payload); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+}
+
+func (e *encodeState) marshalIP(ip net.IP) []byte {
+	return e.appendOptional(nil, ip, ip != nil)
+}
+
+func (d *decodeState) readIP() (net.IP, error) {
+	payload, present, err := d.readOptional()
+	if err != nil || !present {
+		return nil, err
+	}
+	return append(net.IP{}, payload...), nil
+}
+
+func (d *decodeState) unmarshalIP() (any, error) {
+	return d.readIP()
+}
+
+func (e *encodeState) marshalIPNet(ipNet net.IPNet) []byte {
+	serialized := e.marshalIP(ipNet.IP)
+	return e.appendOptional(serialized, ipNet.Mask, ipNet.Mask != nil)
+}
+
+func (d *decodeState) unmarshalIPNet() (any, error) {
+	ip, err := d.readIP()
+	if err != nil {
+		return nil, fmt.Errorf("can't read network address: %w", err)
+	}
+	mask, err := d.readIP()
+	if err != nil {
+		return nil, fmt.Errorf("can't read network mask: %w", err)
+	}
+	return net.IPNet{IP: ip, Mask: net.IPMask(mask)}, nil
+}
+
+var (
+	ipType       = reflect.TypeOf(net.IP{})
+	ipNetType    = reflect.TypeOf(net.IPNet{})
+	addrType     = reflect.TypeOf(netip.Addr{})
+	addrPortType = reflect.TypeOf(netip.AddrPort{})
+	prefixType   = reflect.TypeOf(netip.Prefix{})
+	urlType      = reflect.TypeOf(url.URL{})
+)
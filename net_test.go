@@ -0,0 +1,82 @@
+package goser
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestNetworkTypes(t *testing.T) {
+	_, network, err := net.ParseCIDR("10.1.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := []any{
+		netip.MustParseAddr("fe80::1%eth0"),
+		netip.MustParseAddr("192.0.2.1"),
+		netip.Addr{},
+		netip.MustParseAddrPort("[2001:db8::1]:443"),
+		netip.MustParsePrefix("192.0.2.0/24"),
+		net.ParseIP("192.0.2.1"),
+		net.IP{127, 0, 0, 1},
+		net.IP(nil),
+		*network,
+		network,
+		url.URL{Scheme: "https", User: url.UserPassword("u", "p"), Host: "example.com:8443", Path: "/a/b", RawQuery: "q=1", Fragment: "top"},
+		&url.URL{Scheme: "mailto", Opaque: "someone@example.com"},
+		(*url.URL)(nil),
+	}
+	for _, original := range values {
+		decoded, err := Unmarshal(mustMarshal(t, original))
+		if err != nil {
+			t.Error(fmt.Errorf("%#v: %w", original, err))
+			continue
+		}
+		if !reflect.DeepEqual(decoded, original) {
+			t.Error(fmt.Errorf("before and after for %#v is not the same: %#v", original, decoded))
+		}
+	}
+}
+
+func TestNetworkTypesInStruct(t *testing.T) {
+	type RequestMetadata struct {
+		Client  netip.AddrPort
+		Via     []netip.Addr
+		Network net.IPNet
+		Origin  *url.URL
+	}
+	codec := NewCodec()
+	codec.MustRegister(RequestMetadata{})
+	original := RequestMetadata{
+		Client:  netip.MustParseAddrPort("198.51.100.7:50000"),
+		Via:     []netip.Addr{netip.MustParseAddr("::1")},
+		Network: net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)},
+		Origin:  &url.URL{Scheme: "http", Host: "localhost"},
+	}
+	serialized, err := codec.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded RequestMetadata
+	if err := codec.UnmarshalInto(serialized, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Error(fmt.Errorf("before and after for RequestMetadata is not the same: %#v", decoded))
+	}
+}
+
+func TestNetworkIPCopied(t *testing.T) {
+	serialized := mustMarshal(t, net.IP{1, 2, 3, 4})
+	decoded, err := UnmarshalT[net.IP](serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialized[len(serialized)-1] = 9
+	if !decoded.Equal(net.IP{1, 2, 3, 4}) {
+		t.Error(fmt.Errorf("decoded ip shares memory with the input: %v", decoded))
+	}
+}
@@ -0,0 +1,129 @@
+package goser
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// WithReferences makes the codec write every pointer, map and slice only
+// once and refer back to it wherever it shows up again, so values pointing
+// to the same data still share it after unmarshalling and cyclic structures
+// can be marshalled at all. The first occurrence is written as tagTracked
+// and a reference id, later ones as tagRef and the id. Unmarshalling
+// understands references regardless of this option.
+func WithReferences() Option {
+	return func(c *Codec) {
+		c.references = true
+	}
+}
+
+type refKey struct {
+	theType reflect.Type
+	pointer uintptr
+	length  int
+}
+
+// trackRef returns the reference to value if it was seen before, otherwise
+// it assigns value the next reference id and returns the tagTracked prefix
+// to write in front of it.
+func (e *encodeState) trackRef(value reflect.Value) ([]byte, bool) {
+	key := refKey{theType: value.Type(), pointer: value.Pointer()}
+	if value.Kind() == reflect.Slice {
+		key.length = value.Len()
+	}
+	if refId, seen := e.refs[key]; seen {
+		return e.appendLength([]byte{byte(tagRef)}, int(refId)), true
+	}
+	refId := len(e.refs)
+	e.refs[key] = uint64(refId)
+	return e.appendLength([]byte{byte(tagTracked)}, refId), false
+}
+
+// Tracked pointers carry the type they point to ahead of their contents, so
+// the pointer can be allocated, and referred to, before the contents are
+// unmarshalled.
+func (e *encodeState) marshalTrackedPointer(value reflect.Value) ([]byte, error) {
+	serialized, seen := e.trackRef(value)
+	if seen {
+		return serialized, nil
+	}
+	encodedType, err := e.marshalType(value.Type().Elem())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't serialize pointer type: %w", err)
+	}
+	serialized = append(serialized, byte(reflect.Pointer))
+	serialized = append(serialized, encodedType...)
+	encodedPointerContents, err := e.marshal(value.Elem().Interface())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't serialize pointer contents: %w", err)
+	}
+	return append(serialized, encodedPointerContents...), nil
+}
+
+func (d *decodeState) unmarshalTracked() (any, error) {
+	refId, err := d.readLength()
+	if err != nil {
+		return nil, fmt.Errorf("can't read reference id: %w", err)
+	}
+	kind, err := d.readKind()
+	if err != nil {
+		return nil, err
+	}
+	if d.refs == nil {
+		d.refs = make(map[uint64]reflect.Value)
+	}
+	if _, duplicate := d.refs[refId]; duplicate {
+		return nil, fmt.Errorf("reference id %v is used twice", refId)
+	}
+	track := func(value reflect.Value) {
+		d.refs[refId] = value
+		d.refOrder = append(d.refOrder, refId)
+	}
+	switch kind {
+	case reflect.Pointer:
+		elemType, err := d.unmarshalType()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't deserialize pointer type: %w", err)
+		}
+		if err := d.src.require(d.minimumEncodedSize(elemType)); err != nil {
+			return nil, fmt.Errorf("can't read pointer contents: %w", err)
+		}
+		pointer := reflect.New(elemType)
+		track(pointer)
+		obj, err := d.unmarshalRecursive()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't deserialize pointer contents: %w", err)
+		}
+		if err := assign(pointer.Elem(), obj, ""); err != nil {
+			return nil, fmt.Errorf("couldn't deserialize pointer contents: %w", err)
+		}
+		return pointer.Interface(), nil
+	case reflect.Slice:
+		return d.unmarshalSlice(track)
+	case reflect.Map:
+		return d.unmarshalMap(track)
+	default:
+		return nil, fmt.Errorf("can't deserialize tracked kind %v", kind)
+	}
+}
+
+// dropRefsSince forgets the reference ids defined after the first count,
+// for when the value defining them couldn't be decoded.
+func (d *decodeState) dropRefsSince(count int) {
+	for _, refId := range d.refOrder[count:] {
+		delete(d.refs, refId)
+	}
+	d.refOrder = d.refOrder[:count]
+}
+
+func (d *decodeState) unmarshalRef() (any, error) {
+	refId, err := d.readLength()
+	if err != nil {
+		return nil, fmt.Errorf("can't read reference id: %w", err)
+	}
+	value, known := d.refs[refId]
+	if !known {
+		return nil, fmt.Errorf("can't deserialize reference to unknown id %v", refId)
+	}
+	return value.Interface(), nil
+}
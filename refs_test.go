@@ -0,0 +1,173 @@
+package goser
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type listNode struct {
+	value int
+	prev  *listNode
+	next  *listNode
+}
+
+func TestReferencesCycle(t *testing.T) {
+	codec := NewCodec(WithReferences())
+	codec.MustRegister(listNode{})
+	first := &listNode{value: 1}
+	second := &listNode{value: 2, prev: first}
+	third := &listNode{value: 3, prev: second, next: first}
+	first.next = second
+	second.next = third
+	first.prev = third
+	serialized, err := codec.Marshal(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Unmarshal(serialized); err == nil {
+		t.Error(fmt.Errorf("no error raised for type unknown to the default codec"))
+	}
+	decoded, err := codec.Unmarshal(serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := decoded.(*listNode)
+	for i := 0; i < 3; i++ {
+		if node.next.prev != node || node.prev.next != node {
+			t.Error(fmt.Errorf("links of node %d are not preserved", node.value))
+		}
+		node = node.next
+	}
+	if node != decoded.(*listNode) || node.next.next.value != 3 {
+		t.Error(fmt.Errorf("cycle is not preserved"))
+	}
+}
+
+func TestReferencesSharing(t *testing.T) {
+	type Shared struct {
+		a, b   *int
+		s1, s2 []string
+		m1, m2 map[string]int
+	}
+	codec := NewCodec(WithReferences())
+	codec.MustRegister(Shared{})
+	number := 5
+	texts := []string{"x", "y"}
+	counts := map[string]int{"z": 1}
+	serialized, err := codec.Marshal(&Shared{a: &number, b: &number, s1: texts, s2: texts, m1: counts, m2: counts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded *Shared
+	if err := codec.UnmarshalInto(serialized, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.a != decoded.b || *decoded.a != 5 {
+		t.Error(fmt.Errorf("shared pointer decoded as two copies"))
+	}
+	decoded.s1[0] = "changed"
+	if decoded.s2[0] != "changed" {
+		t.Error(fmt.Errorf("shared slice decoded as two copies"))
+	}
+	decoded.m1["new"] = 2
+	if decoded.m2["new"] != 2 {
+		t.Error(fmt.Errorf("shared map decoded as two copies"))
+	}
+
+	copied, err := NewCodec().Marshal([]*int{&number, &number})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pointers, err := UnmarshalT[[]*int](copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pointers[0] == pointers[1] {
+		t.Error(fmt.Errorf("pointers shared without reference tracking"))
+	}
+}
+
+func TestReferencesSelfContaining(t *testing.T) {
+	codec := NewCodec(WithReferences())
+	self := map[string]any{"name": "root"}
+	self["self"] = self
+	serialized, err := codec.Marshal(self)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := codec.Unmarshal(serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedMap := decoded.(map[string]any)
+	decodedMap["marker"] = true
+	if decodedMap["self"].(map[string]any)["marker"] != true {
+		t.Error(fmt.Errorf("self reference of map is not preserved"))
+	}
+}
+
+func TestReferencesUnknown(t *testing.T) {
+	bytes := appendLength([]byte{byte(tagRef)}, 3)
+	if _, err := Unmarshal(bytes); err == nil {
+		t.Error(fmt.Errorf("no error raised for unknown reference"))
+	}
+	bytes = append(appendLength([]byte{byte(tagTracked)}, 0), mustMarshal(t, 1)...)
+	if _, err := Unmarshal(bytes); err == nil {
+		t.Error(fmt.Errorf("no error raised for tracked int"))
+	}
+}
+
+func TestReferencesKeyedStructs(t *testing.T) {
+	type Attachment struct {
+		name string
+	}
+	type DocumentV1 struct {
+		draft      []string
+		tags       []string
+		parent     *listNode
+		owner      *listNode
+		attachment *Attachment
+	}
+	type DocumentV2 struct {
+		tags   []string
+		parent *listNode
+		owner  *listNode
+	}
+	tags := []string{"a", "b"}
+	root := &listNode{value: 1}
+	root.next = root
+	writer := NewCodec(WithReferences(), WithKeyedStructs())
+	writer.RegisterName(DocumentV1{}, "document")
+	writer.MustRegister(listNode{})
+	writer.MustRegister(Attachment{})
+	serialized, err := writer.Marshal(DocumentV1{draft: tags, tags: tags, parent: root, owner: root, attachment: &Attachment{name: "a.txt"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var original DocumentV1
+	if err := writer.UnmarshalInto(serialized, &original); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(original.tags, tags) || original.parent.next != original.parent || original.attachment.name != "a.txt" {
+		t.Error(fmt.Errorf("before and after for DocumentV1 is not the same: %#v", original))
+	}
+	if original.owner != original.parent {
+		t.Error(fmt.Errorf("pointer shared by two keyed fields decoded as two copies"))
+	}
+	original.draft[0] = "changed"
+	if original.tags[0] != "changed" {
+		t.Error(fmt.Errorf("slice shared by two keyed fields decoded as two copies"))
+	}
+
+	reader := NewCodec()
+	reader.RegisterName(DocumentV2{}, "document")
+	reader.MustRegister(listNode{})
+	var evolved DocumentV2
+	if err := reader.UnmarshalInto(serialized, &evolved); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(evolved.tags, tags) || evolved.parent.value != 1 || evolved.parent.next != evolved.parent || evolved.owner != evolved.parent {
+		t.Error(fmt.Errorf("before and after for DocumentV2 is not the same: %#v", evolved))
+	}
+}
@@ -0,0 +1,141 @@
+package goser
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// registry maps type ids to types and back. Lookups read an immutable
+// snapshot without locking; registrations are serialized by a mutex and
+// publish a modified copy, as they are rare compared to lookups.
+type registry struct {
+	mutex    sync.Mutex
+	snapshot atomic.Pointer[registrySnapshot]
+}
+
+// ErrIDCollision is wrapped by the error Register returns when a type's id is
+// already taken by a different type.
+var ErrIDCollision = errors.New("type id collision")
+
+// ErrReservedID is wrapped by the error Register returns when a type id is
+// reserved by the wire format.
+var ErrReservedID = errors.New("reserved type id")
+
+type registrySnapshot struct {
+	idToType map[uint32]reflect.Type
+	typeToId map[reflect.Type]uint32
+	codecs   map[reflect.Type]*customCodec
+}
+
+func newRegistry() *registry {
+	r := &registry{}
+	r.snapshot.Store(&registrySnapshot{
+		idToType: make(map[uint32]reflect.Type),
+		typeToId: make(map[reflect.Type]uint32),
+		codecs:   make(map[reflect.Type]*customCodec),
+	})
+	return r
+}
+
+func (r *registry) typeById(typeId uint32) (reflect.Type, bool) {
+	theType, typeKnown := r.snapshot.Load().idToType[typeId]
+	return theType, typeKnown
+}
+
+func (r *registry) idByType(theType reflect.Type) (uint32, bool) {
+	typeId, typeKnown := r.snapshot.Load().typeToId[theType]
+	return typeId, typeKnown
+}
+
+// lookup returns the id theType is marshalled with and its custom codec, if
+// any, from a single snapshot.
+func (r *registry) lookup(theType reflect.Type) (uint32, *customCodec, bool) {
+	current := r.snapshot.Load()
+	typeId, typeKnown := current.typeToId[theType]
+	if !typeKnown {
+		return 0, nil, false
+	}
+	return typeId, current.codecs[theType], true
+}
+
+func (r *registry) codecById(typeId uint32) (reflect.Type, *customCodec, bool) {
+	current := r.snapshot.Load()
+	theType, typeKnown := current.idToType[typeId]
+	if !typeKnown {
+		return nil, nil, false
+	}
+	custom, hasCodec := current.codecs[theType]
+	return theType, custom, hasCodec
+}
+
+func (r *registry) types() map[uint32]reflect.Type {
+	current := r.snapshot.Load()
+	types := make(map[uint32]reflect.Type, len(current.idToType))
+	for id, t := range current.idToType {
+		types[id] = t
+	}
+	return types
+}
+
+// add registers theType under typeId. Aliases are only used for lookups by
+// id, so they never change the id a type is marshalled with, while a plain
+// registration makes typeId the marshal id even when typeId already was an
+// alias of theType. A non-nil custom codec replaces the one theType had.
+func (r *registry) add(typeId uint32, theType reflect.Type, alias bool, custom *customCodec) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.addLocked(typeId, theType, alias, custom)
+}
+
+// addCodec gives theType a custom codec. A type that is already registered
+// keeps the id it is marshalled with, others get defaultId.
+func (r *registry) addCodec(defaultId uint32, theType reflect.Type, custom *customCodec) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	typeId, typeKnown := r.snapshot.Load().typeToId[theType]
+	if !typeKnown {
+		typeId = defaultId
+	}
+	return r.addLocked(typeId, theType, false, custom)
+}
+
+func (r *registry) addLocked(typeId uint32, theType reflect.Type, alias bool, custom *customCodec) error {
+	if typeId == legacyTimeId {
+		return fmt.Errorf("can't register %v with id %#08x as it is reserved: %w", theType, typeId, ErrReservedID)
+	}
+	current := r.snapshot.Load()
+	if knownType, typeKnown := current.idToType[typeId]; typeKnown {
+		if knownType != theType {
+			return fmt.Errorf("can't register %v with id %#08x as it belongs to %v: %w", theType, typeId, knownType, ErrIDCollision)
+		}
+		if custom == nil && (alias || current.typeToId[theType] == typeId) {
+			return nil
+		}
+	}
+	updated := &registrySnapshot{
+		idToType: make(map[uint32]reflect.Type, len(current.idToType)+1),
+		typeToId: make(map[reflect.Type]uint32, len(current.typeToId)+1),
+		codecs:   make(map[reflect.Type]*customCodec, len(current.codecs)+1),
+	}
+	for id, t := range current.idToType {
+		updated.idToType[id] = t
+	}
+	for t, id := range current.typeToId {
+		updated.typeToId[t] = id
+	}
+	for t, c := range current.codecs {
+		updated.codecs[t] = c
+	}
+	updated.idToType[typeId] = theType
+	if !alias {
+		updated.typeToId[theType] = typeId
+	}
+	if custom != nil {
+		updated.codecs[theType] = custom
+	}
+	r.snapshot.Store(updated)
+	return nil
+}
@@ -0,0 +1,182 @@
+package goser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// "struct { F16789 int }" and "struct { F215890 int }" share the FNV-1a hash
+// 0xda16cbfc.
+func collidingValues() (any, any) {
+	first := reflect.StructOf([]reflect.StructField{{Name: "F16789", Type: reflect.TypeOf(0)}})
+	second := reflect.StructOf([]reflect.StructField{{Name: "F215890", Type: reflect.TypeOf(0)}})
+	return reflect.New(first).Elem().Interface(), reflect.New(second).Elem().Interface()
+}
+
+func TestRegisterCollision(t *testing.T) {
+	first, second := collidingValues()
+	codec := NewCodec()
+	if err := codec.Register(first); err != nil {
+		t.Error(err)
+	}
+	if err := codec.Register(first); err != nil {
+		t.Error(fmt.Errorf("registering the same type twice raised %v", err))
+	}
+	err := codec.Register(second)
+	if !errors.Is(err, ErrIDCollision) {
+		t.Error(fmt.Errorf("expected ErrIDCollision, got %v", err))
+	}
+	if codec.RegisteredTypes()[0xda16cbfc] != reflect.TypeOf(first) {
+		t.Error(fmt.Errorf("colliding type replaced the registered one"))
+	}
+}
+
+func TestMustRegisterCollision(t *testing.T) {
+	first, second := collidingValues()
+	codec := NewCodec()
+	codec.MustRegister(first)
+	defer func() {
+		if recover() == nil {
+			t.Error(fmt.Errorf("no panic raised"))
+		}
+	}()
+	codec.MustRegister(second)
+}
+
+func TestRegisterNil(t *testing.T) {
+	if err := NewCodec().Register(nil); err == nil {
+		t.Error(fmt.Errorf("no error raised"))
+	}
+}
+
+func TestRegisteredTypes(t *testing.T) {
+	type Listed struct{}
+	codec := NewCodec()
+	codec.MustRegister(Listed{})
+	types := codec.RegisteredTypes()
+	if len(types) != 1 {
+		t.Error(fmt.Errorf("expected one registered type, got %v", types))
+	}
+	for id, theType := range types {
+		if theType != reflect.TypeOf(Listed{}) {
+			t.Error(fmt.Errorf("unexpected type %v for id %#08x", theType, id))
+		}
+	}
+}
+
+func TestRegisterName(t *testing.T) {
+	type Renamed struct {
+		value int
+	}
+	codec := NewCodec()
+	if err := codec.RegisterName(&Renamed{}, "billing.Invoice"); err != nil {
+		t.Error(err)
+	}
+	serialized, err := codec.Marshal(Renamed{value: 1})
+	if err != nil {
+		t.Error(err)
+	}
+	if got := binary.LittleEndian.Uint32(serialized[1:5]); got != typeIdForName("billing.Invoice") {
+		t.Error(fmt.Errorf("expected id of the registered name, got %#08x", got))
+	}
+	decoded, err := codec.Unmarshal(serialized)
+	if err != nil {
+		t.Error(err)
+	}
+	if decoded.(Renamed).value != 1 {
+		t.Error(fmt.Errorf("before and after for Renamed is not the same"))
+	}
+}
+
+func TestRegisterWithID(t *testing.T) {
+	type Pinned struct {
+		value string
+	}
+	codec := NewCodec()
+	if err := codec.RegisterWithID(Pinned{}, 42); err != nil {
+		t.Error(err)
+	}
+	serialized, err := codec.Marshal(Pinned{value: "p"})
+	if err != nil {
+		t.Error(err)
+	}
+	if got := binary.LittleEndian.Uint32(serialized[1:5]); got != 42 {
+		t.Error(fmt.Errorf("expected id 42, got %#08x", got))
+	}
+	type Other struct{}
+	if err := codec.RegisterWithID(Other{}, 42); !errors.Is(err, ErrIDCollision) {
+		t.Error(fmt.Errorf("expected ErrIDCollision, got %v", err))
+	}
+}
+
+func TestRegisterAlias(t *testing.T) {
+	type Before struct {
+		value int
+	}
+	type After struct {
+		value int
+	}
+	oldCodec := NewCodec()
+	oldCodec.MustRegister(Before{})
+	oldSerialized, err := oldCodec.Marshal(Before{value: 5})
+	if err != nil {
+		t.Error(err)
+	}
+
+	codec := NewCodec()
+	codec.MustRegister(After{})
+	if err := codec.RegisterAliasName(After{}, "github.com/ejobsgroup/goser.Before"); err != nil {
+		t.Error(err)
+	}
+	if err := codec.RegisterAlias(After{}, 7); err != nil {
+		t.Error(err)
+	}
+	decoded, err := codec.Unmarshal(oldSerialized)
+	if err != nil {
+		t.Error(err)
+	}
+	if decoded.(After).value != 5 {
+		t.Error(fmt.Errorf("before and after for aliased type is not the same"))
+	}
+	aliased := append([]byte{byte(reflect.Struct), 7, 0, 0, 0}, mustMarshal(t, 6)...)
+	decoded, err = codec.Unmarshal(aliased)
+	if err != nil {
+		t.Error(err)
+	}
+	if decoded.(After).value != 6 {
+		t.Error(fmt.Errorf("before and after for aliased id is not the same"))
+	}
+	newSerialized, err := codec.Marshal(After{})
+	if err != nil {
+		t.Error(err)
+	}
+	if got := binary.LittleEndian.Uint32(newSerialized[1:5]); got != typeIdForName("github.com/ejobsgroup/goser.After") {
+		t.Error(fmt.Errorf("marshalled with alias id %#08x", got))
+	}
+}
+
+func TestRegisterAfterAlias(t *testing.T) {
+	type Promoted struct {
+		value int
+	}
+	codec := NewCodec()
+	if err := codec.RegisterAlias(Promoted{}, 99); err != nil {
+		t.Error(err)
+	}
+	if _, err := codec.Marshal(Promoted{}); err == nil {
+		t.Error(fmt.Errorf("no error raised for type registered only as an alias"))
+	}
+	if err := codec.RegisterWithID(Promoted{}, 99); err != nil {
+		t.Error(err)
+	}
+	serialized, err := codec.Marshal(Promoted{value: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.LittleEndian.Uint32(serialized[1:5]); got != 99 {
+		t.Error(fmt.Errorf("expected id 99, got %#08x", got))
+	}
+}
@@ -0,0 +1,168 @@
+package goser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+)
+
+// source hands out the encoded bytes to unmarshalRecursive. The returned
+// slice holds exactly n bytes or an error is returned. peek returns io.EOF
+// when there are no bytes left, and require fails unless at least n more
+// bytes are there, so lengths read from the input can be checked before
+// anything is allocated for them.
+type source interface {
+	next(n uint64) ([]byte, error)
+	peek() error
+	require(n uint64) error
+}
+
+type sliceSource struct {
+	data []byte
+}
+
+func (s *sliceSource) next(n uint64) ([]byte, error) {
+	if uint64(len(s.data)) < n {
+		return nil, fmt.Errorf("need %v bytes but only %v are left: %w", n, len(s.data), io.ErrUnexpectedEOF)
+	}
+	chunk := s.data[:n]
+	s.data = s.data[n:]
+	return chunk, nil
+}
+
+func (s *sliceSource) require(n uint64) error {
+	if uint64(len(s.data)) < n {
+		return fmt.Errorf("need %v bytes but only %v are left: %w", n, len(s.data), io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+func (s *sliceSource) peek() error {
+	if len(s.data) == 0 {
+		return io.EOF
+	}
+	return nil
+}
+
+// readerSource doesn't trust lengths read from the stream for allocations,
+// so a corrupt length fails with io.ErrUnexpectedEOF instead of reserving
+// the memory up front. Bytes read ahead by require are kept in ahead until
+// next hands them out.
+type readerSource struct {
+	reader *bufio.Reader
+	ahead  []byte
+}
+
+const readerSourceChunk = 4096
+
+func (s *readerSource) next(n uint64) ([]byte, error) {
+	if len(s.ahead) == 0 {
+		return s.read(n)
+	}
+	if err := s.require(n); err != nil {
+		return nil, err
+	}
+	chunk := s.ahead[:n:n]
+	s.ahead = s.ahead[n:]
+	return chunk, nil
+}
+
+func (s *readerSource) require(n uint64) error {
+	if uint64(len(s.ahead)) >= n {
+		return nil
+	}
+	more, err := s.read(n - uint64(len(s.ahead)))
+	if err != nil {
+		return err
+	}
+	s.ahead = append(s.ahead, more...)
+	return nil
+}
+
+func (s *readerSource) read(n uint64) ([]byte, error) {
+	if n <= readerSourceChunk {
+		chunk := make([]byte, n)
+		if _, err := io.ReadFull(s.reader, chunk); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return chunk, nil
+	}
+	if n > math.MaxInt64 {
+		return nil, fmt.Errorf("can't read %v bytes", n)
+	}
+	var buffer bytes.Buffer
+	if _, err := io.CopyN(&buffer, s.reader, int64(n)); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return buffer.Bytes(), nil
+}
+
+func (s *readerSource) peek() error {
+	if len(s.ahead) > 0 {
+		return nil
+	}
+	_, err := s.reader.Peek(1)
+	return err
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Encoder writes a sequence of values to an io.Writer, each in the same
+// format produced by Marshal.
+type Encoder struct {
+	codec  *Codec
+	writer io.Writer
+}
+
+func NewEncoder(writer io.Writer) *Encoder {
+	return defaultCodec.NewEncoder(writer)
+}
+
+func (e *Encoder) Encode(obj any) error {
+	serialized, err := e.codec.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = e.writer.Write(serialized)
+	return err
+}
+
+// Decoder reads a sequence of values written by an Encoder (or concatenated
+// Marshal outputs) from an io.Reader. It may read ahead from the underlying
+// reader. Decode returns io.EOF once the stream ends between two values.
+type Decoder struct {
+	codec *Codec
+	src   source
+}
+
+func NewDecoder(reader io.Reader) *Decoder {
+	return defaultCodec.NewDecoder(reader)
+}
+
+func (d *Decoder) Decode() (any, error) {
+	if err := d.src.peek(); err != nil {
+		return nil, err
+	}
+	state := &decodeState{codec: d.codec, src: d.src}
+	return state.unmarshalValue()
+}
+
+// DecodeInto is Decode followed by the same conversion UnmarshalInto does.
+func (d *Decoder) DecodeInto(target any) error {
+	targetValue, err := intoTarget(target)
+	if err != nil {
+		return err
+	}
+	value, err := d.Decode()
+	if err != nil {
+		return err
+	}
+	return assign(targetValue, value, "")
+}
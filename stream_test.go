@@ -0,0 +1,114 @@
+package goser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestStreamRoundTrip(t *testing.T) {
+	var buffer bytes.Buffer
+	encoder := NewEncoder(&buffer)
+	values := []any{1, "two", []float64{3}, map[string]int{"four": 4}, nil}
+	for _, value := range values {
+		if err := encoder.Encode(value); err != nil {
+			t.Error(err)
+		}
+	}
+	decoder := NewDecoder(&buffer)
+	for i, value := range values {
+		decoded, err := decoder.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(decoded) != fmt.Sprint(value) {
+			t.Error(fmt.Errorf("before and after for stream value %d is not the same", i))
+		}
+	}
+	_, err := decoder.Decode()
+	if err != io.EOF {
+		t.Error(fmt.Errorf("expected io.EOF at the end of the stream, got %v", err))
+	}
+}
+
+func TestStreamDecodeInto(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := NewEncoder(&buffer).Encode([]int32{5, 6}); err != nil {
+		t.Error(err)
+	}
+	var decoded []int32
+	if err := NewDecoder(&buffer).DecodeInto(&decoded); err != nil {
+		t.Error(err)
+	}
+	if len(decoded) != 2 || decoded[1] != 6 {
+		t.Error(fmt.Errorf("before and after for []int32 is not the same"))
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	serialized := mustMarshal(t, "a longer string")
+	_, err := NewDecoder(bytes.NewReader(serialized[:len(serialized)-3])).Decode()
+	if err == nil || err == io.EOF {
+		t.Error(fmt.Errorf("expected an error for a truncated stream, got %v", err))
+	}
+}
+
+func TestStreamHugeLength(t *testing.T) {
+	serialized := []byte{byte(reflect.String), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 'x'}
+	_, err := NewDecoder(bytes.NewReader(serialized)).Decode()
+	if err == nil {
+		t.Error(fmt.Errorf("no error raised for huge string length"))
+	}
+}
+
+func TestStreamHugeCollectionLength(t *testing.T) {
+	huge := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}
+	negative := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	thousand := []byte{0xe8, 0x03, 0, 0, 0, 0, 0, 0}
+	bigArrayMarker := append([]byte{byte(reflect.Array)}, thousand...)
+	bigArrayMarker = append(bigArrayMarker, byte(reflect.Int8), 0)
+	for i := 0; i < 1000; i++ {
+		bigArrayMarker = append(bigArrayMarker, byte(reflect.Int8), 0)
+	}
+	payloads := map[string][]byte{
+		"slice":          append(append([]byte{byte(reflect.Slice)}, huge...), byte(reflect.Int), 0, 0, 0, 0, 0, 0, 0, 0),
+		"negative slice": append(append([]byte{byte(reflect.Slice)}, negative...), byte(reflect.Int), 0, 0, 0, 0, 0, 0, 0, 0),
+		"array":          append(append([]byte{byte(reflect.Array)}, huge...), byte(reflect.Int), 0, 0, 0, 0, 0, 0, 0, 0),
+		"negative array": append(append([]byte{byte(reflect.Array)}, negative...), byte(reflect.Int), 0, 0, 0, 0, 0, 0, 0, 0),
+		"big items":      append(append([]byte{byte(reflect.Slice)}, thousand...), bigArrayMarker...),
+	}
+	for name, payload := range payloads {
+		if _, err := Unmarshal(payload); err == nil {
+			t.Error(fmt.Errorf("no error raised for %v", name))
+		}
+		if _, err := NewDecoder(bytes.NewReader(payload)).Decode(); err == nil {
+			t.Error(fmt.Errorf("no error raised for streamed %v", name))
+		}
+	}
+}
+
+func TestStreamReadAhead(t *testing.T) {
+	var buffer bytes.Buffer
+	encoder := NewEncoder(&buffer)
+	original := [][]int{{1, 2, 3}, {}, {4}}
+	for _, value := range original {
+		if err := encoder.Encode(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	decoder := NewDecoder(&buffer)
+	for _, value := range original {
+		decoded, err := decoder.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, value) {
+			t.Error(fmt.Errorf("before and after for %v is not the same: %v", value, decoded))
+		}
+	}
+	if _, err := decoder.Decode(); err != io.EOF {
+		t.Error(fmt.Errorf("expected io.EOF, got %v", err))
+	}
+}
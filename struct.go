@@ -0,0 +1,241 @@
+package goser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// WithKeyedStructs makes the codec write every struct field together with
+// its name, so adding, removing or reordering fields doesn't break reading
+// data written before the change: unknown fields are skipped and missing
+// ones are left at their zero value. Unmarshalling understands both struct
+// encodings regardless of this option.
+func WithKeyedStructs() Option {
+	return func(c *Codec) {
+		c.keyedStructs = true
+	}
+}
+
+// structField describes how a struct field is written, as configured by its
+// goser tag: `goser:"-"` skips the field, `goser:"name"` writes it under a
+// different name in keyed mode, `goser:",num=3"` writes it under a number
+// instead, so it can be renamed freely, and `goser:",omitempty"` leaves it
+// out while it holds its zero value.
+type structField struct {
+	index     int
+	name      string
+	number    uint64
+	omitEmpty bool
+}
+
+type structFields struct {
+	list     []structField
+	byName   map[string]int
+	byNumber map[uint64]int
+}
+
+var structFieldsCache sync.Map
+
+func cachedStructFields(theType reflect.Type) (*structFields, error) {
+	if fields, ok := structFieldsCache.Load(theType); ok {
+		return fields.(*structFields), nil
+	}
+	fields, err := parseStructFields(theType)
+	if err != nil {
+		return nil, err
+	}
+	structFieldsCache.Store(theType, fields)
+	return fields, nil
+}
+
+func parseStructFields(theType reflect.Type) (*structFields, error) {
+	if theType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("can't list the fields of %v (not a struct)", theType)
+	}
+	fields := &structFields{
+		byName:   make(map[string]int),
+		byNumber: make(map[uint64]int),
+	}
+	for i := 0; i < theType.NumField(); i++ {
+		goField := theType.Field(i)
+		tag := goField.Tag.Get("goser")
+		if tag == "-" {
+			continue
+		}
+		field := structField{index: i, name: goField.Name}
+		options := strings.Split(tag, ",")
+		if options[0] != "" {
+			field.name = options[0]
+		}
+		for _, option := range options[1:] {
+			switch {
+			case strings.HasPrefix(option, "num="):
+				number, err := strconv.ParseUint(strings.TrimPrefix(option, "num="), 10, 64)
+				if err != nil || number == 0 {
+					return nil, fmt.Errorf("invalid field number in tag of %v.%v: %q", theType, goField.Name, option)
+				}
+				field.number = number
+			case option == "omitempty":
+				field.omitEmpty = true
+			default:
+				return nil, fmt.Errorf("unknown option in tag of %v.%v: %q", theType, goField.Name, option)
+			}
+		}
+		if _, duplicate := fields.byName[field.name]; duplicate {
+			return nil, fmt.Errorf("duplicate field name %q in %v", field.name, theType)
+		}
+		fields.byName[field.name] = len(fields.list)
+		if field.number != 0 {
+			if _, duplicate := fields.byNumber[field.number]; duplicate {
+				return nil, fmt.Errorf("duplicate field number %v in %v", field.number, theType)
+			}
+			fields.byNumber[field.number] = len(fields.list)
+		}
+		fields.list = append(fields.list, field)
+	}
+	return fields, nil
+}
+
+// WithOmitEmpty makes the codec treat every struct field as if it was tagged
+// with omitempty.
+func WithOmitEmpty() Option {
+	return func(c *Codec) {
+		c.omitEmpty = true
+	}
+}
+
+// omitField reports whether a field is left out for holding its zero value.
+// Keyed structs drop such fields, positional ones write a single tagZero
+// byte in their place.
+func (e *encodeState) omitField(field structField, value reflect.Value) bool {
+	return (field.omitEmpty || e.codec.omitEmpty) && value.IsZero()
+}
+
+func (e *encodeState) marshalKeyedStruct(typeId uint32, fields *structFields, value reflect.Value) ([]byte, error) {
+	serialized := []byte{byte(tagKeyedStruct)}
+	encodedTypeId := make([]byte, 4)
+	binary.LittleEndian.PutUint32(encodedTypeId, typeId)
+	serialized = append(serialized, encodedTypeId...)
+	structCopy := reflect.New(value.Type()).Elem()
+	structCopy.Set(value)
+	encodedFields := make([]byte, 0)
+	fieldCount := 0
+	for _, field := range fields.list {
+		unsafeField := structCopy.Field(field.index)
+		unsafeField = reflect.NewAt(unsafeField.Type(), unsafe.Pointer(unsafeField.UnsafeAddr())).Elem()
+		if e.omitField(field, unsafeField) {
+			continue
+		}
+		var key any = field.name
+		if field.number != 0 {
+			key = field.number
+		}
+		encodedKey, err := e.marshal(key)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't serialize struct field key: %w", err)
+		}
+		encodedFields = append(encodedFields, encodedKey...)
+		encodedField, err := e.marshal(unsafeField.Interface())
+		if err != nil {
+			return nil, fmt.Errorf("couldn't serialize struct field: %w", err)
+		}
+		encodedFields = e.appendLength(encodedFields, len(encodedField))
+		encodedFields = append(encodedFields, encodedField...)
+		fieldCount++
+	}
+	serialized = e.appendLength(serialized, fieldCount)
+	serialized = append(serialized, encodedFields...)
+	return serialized, nil
+}
+
+func (d *decodeState) unmarshalKeyedStruct() (any, error) {
+	encodedTypeId, err := d.src.next(4)
+	if err != nil {
+		return nil, fmt.Errorf("can't read struct type id: %w", err)
+	}
+	typeId := binary.LittleEndian.Uint32(encodedTypeId)
+	theType, typeKnown := d.codec.registry.typeById(typeId)
+	if !typeKnown {
+		return nil, fmt.Errorf("can't deserialize type id %v (not registered)", typeId)
+	}
+	if theType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("can't deserialize type id %v as struct (registered as %v)", typeId, theType)
+	}
+	fields, err := cachedStructFields(theType)
+	if err != nil {
+		return nil, err
+	}
+	fieldCount, err := d.readLength()
+	if err != nil {
+		return nil, fmt.Errorf("can't read struct field count: %w", err)
+	}
+	structCopy := reflect.New(theType).Elem()
+	for i := uint64(0); i < fieldCount; i++ {
+		key, err := d.unmarshalRecursive()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't deserialize struct field key: %w", err)
+		}
+		length, err := d.readLength()
+		if err != nil {
+			return nil, fmt.Errorf("can't read struct field length: %w", err)
+		}
+		encodedField, err := d.src.next(length)
+		if err != nil {
+			return nil, fmt.Errorf("can't read struct field: %w", err)
+		}
+		var position int
+		var found bool
+		switch key := key.(type) {
+		case string:
+			position, found = fields.byName[key]
+		case uint64:
+			position, found = fields.byNumber[key]
+		default:
+			return nil, fmt.Errorf("expected struct field name or number but got %T", key)
+		}
+		if !found {
+			// Unknown fields are still decoded for the references they
+			// define, which later fields may point back to. One that can't
+			// be decoded, e.g. for holding types this codec doesn't know,
+			// is skipped along with its references.
+			refCount := len(d.refOrder)
+			if _, err := d.unmarshalField(encodedField, key); err != nil {
+				d.dropRefsSince(refCount)
+			}
+			continue
+		}
+		fieldValue, err := d.unmarshalField(encodedField, key)
+		if err != nil {
+			return nil, err
+		}
+		index := fields.list[position].index
+		field := structCopy.Field(index)
+		unsafeField := reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem()
+		if err := assign(unsafeField, fieldValue, theType.Name()+"."+theType.Field(index).Name); err != nil {
+			return nil, fmt.Errorf("couldn't deserialize struct field: %w", err)
+		}
+	}
+	return structCopy.Interface(), nil
+}
+
+// unmarshalField decodes a keyed struct field from its own bytes, which it
+// has to consume completely.
+func (d *decodeState) unmarshalField(encodedField []byte, key any) (any, error) {
+	fieldSource := &sliceSource{data: encodedField}
+	structSource := d.src
+	d.src = fieldSource
+	fieldValue, err := d.unmarshalRecursive()
+	d.src = structSource
+	if err != nil {
+		return nil, fmt.Errorf("couldn't deserialize struct field %v: %w", key, err)
+	}
+	if len(fieldSource.data) > 0 {
+		return nil, fmt.Errorf("couldn't consume all the bytes of struct field %v", key)
+	}
+	return fieldValue, nil
+}
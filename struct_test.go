@@ -0,0 +1,230 @@
+package goser
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestKeyedStructEvolution(t *testing.T) {
+	type Unknown struct{}
+	type ProfileV1 struct {
+		name    string
+		age     int
+		removed Unknown
+	}
+	type ProfileV2 struct {
+		added string
+		age   int
+		name  string
+	}
+	oldCodec := NewCodec(WithKeyedStructs())
+	oldCodec.RegisterName(ProfileV1{}, "profile")
+	oldCodec.Register(Unknown{})
+	serialized, err := oldCodec.Marshal(ProfileV1{name: "ana", age: 30})
+	if err != nil {
+		t.Error(err)
+	}
+	if serialized[0] != byte(tagKeyedStruct) {
+		t.Error(fmt.Errorf("struct not written in keyed mode"))
+	}
+
+	newCodec := NewCodec()
+	newCodec.RegisterName(ProfileV2{}, "profile")
+	var profile ProfileV2
+	if err := newCodec.UnmarshalInto(serialized, &profile); err != nil {
+		t.Error(err)
+	}
+	if profile.name != "ana" || profile.age != 30 || profile.added != "" {
+		t.Error(fmt.Errorf("before and after for evolved struct is not the same: %#v", profile))
+	}
+}
+
+func TestKeyedStructNested(t *testing.T) {
+	type KeyedInner struct {
+		values []int
+	}
+	type KeyedOuter struct {
+		inner *KeyedInner
+		items map[string]KeyedInner
+	}
+	codec := NewCodec(WithKeyedStructs())
+	codec.Register(KeyedInner{})
+	codec.Register(KeyedOuter{})
+	original := KeyedOuter{
+		inner: &KeyedInner{values: []int{1, 2}},
+		items: map[string]KeyedInner{"a": {values: []int{3}}},
+	}
+	serialized, err := codec.Marshal(original)
+	if err != nil {
+		t.Error(err)
+	}
+	decoded, err := codec.Unmarshal(serialized)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Error(fmt.Errorf("before and after for nested keyed struct is not the same"))
+	}
+}
+
+func TestKeyedStructTruncated(t *testing.T) {
+	type Truncated struct {
+		value int
+	}
+	codec := NewCodec(WithKeyedStructs())
+	codec.Register(Truncated{})
+	serialized, err := codec.Marshal(Truncated{value: 1})
+	if err != nil {
+		t.Error(err)
+	}
+	for i := 1; i < len(serialized); i++ {
+		if _, err := codec.Unmarshal(serialized[:i]); err == nil {
+			t.Error(fmt.Errorf("no error raised for keyed struct cut at %d bytes", i))
+		}
+	}
+}
+
+func TestStructTags(t *testing.T) {
+	type Tagged struct {
+		Visible string
+		cache   map[string]int `goser:"-"`
+		onDone  func()         `goser:"-"`
+		Renamed int            `goser:"total"`
+		Counted []string       `goser:",num=4"`
+	}
+	for _, codec := range []*Codec{NewCodec(), NewCodec(WithKeyedStructs())} {
+		if err := codec.Register(Tagged{}); err != nil {
+			t.Error(err)
+		}
+		original := Tagged{Visible: "v", cache: map[string]int{"x": 1}, onDone: func() {}, Renamed: 2, Counted: []string{"c"}}
+		serialized, err := codec.Marshal(original)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		var decoded Tagged
+		if err := codec.UnmarshalInto(serialized, &decoded); err != nil {
+			t.Error(err)
+		}
+		if decoded.cache != nil || decoded.onDone != nil {
+			t.Error(fmt.Errorf("skipped fields were serialized"))
+		}
+		if decoded.Visible != "v" || decoded.Renamed != 2 || !reflect.DeepEqual(decoded.Counted, []string{"c"}) {
+			t.Error(fmt.Errorf("before and after for tagged struct is not the same: %#v", decoded))
+		}
+	}
+}
+
+func TestStructTagRenames(t *testing.T) {
+	type AccountV1 struct {
+		Owner   string `goser:"holder"`
+		Balance int    `goser:",num=1"`
+	}
+	type AccountV2 struct {
+		Holder string
+		Amount int `goser:"amount,num=1"`
+	}
+	oldCodec := NewCodec(WithKeyedStructs())
+	oldCodec.RegisterName(AccountV1{}, "account")
+	serialized, err := oldCodec.Marshal(AccountV1{Owner: "o", Balance: 10})
+	if err != nil {
+		t.Error(err)
+	}
+	newCodec := NewCodec()
+	newCodec.RegisterName(AccountV2{}, "account")
+	var account AccountV2
+	if err := newCodec.UnmarshalInto(serialized, &account); err != nil {
+		t.Error(err)
+	}
+	if account.Holder != "" || account.Amount != 10 {
+		t.Error(fmt.Errorf("before and after for renamed fields is not the same: %#v", account))
+	}
+}
+
+func TestStructTagErrors(t *testing.T) {
+	type BadNumber struct {
+		A int `goser:",num=x"`
+	}
+	type DuplicateNumber struct {
+		A int `goser:",num=1"`
+		B int `goser:",num=1"`
+	}
+	type DuplicateName struct {
+		A int `goser:"B"`
+		B int
+	}
+	type UnknownOption struct {
+		A int `goser:",sometimes"`
+	}
+	for _, value := range []any{BadNumber{}, DuplicateNumber{}, DuplicateName{}, UnknownOption{}} {
+		if err := NewCodec().Register(value); err == nil {
+			t.Error(fmt.Errorf("no error raised for %T", value))
+		}
+	}
+}
+
+func TestOmitEmpty(t *testing.T) {
+	type Sparse struct {
+		Name    string         `goser:",omitempty"`
+		Count   int            `goser:",omitempty"`
+		Labels  map[string]int `goser:",omitempty"`
+		Enabled bool
+	}
+	for _, codec := range []*Codec{NewCodec(), NewCodec(WithKeyedStructs())} {
+		codec.Register(Sparse{})
+		full, err := codec.Marshal(Sparse{Name: "n", Count: 1, Labels: map[string]int{"l": 1}})
+		if err != nil {
+			t.Error(err)
+		}
+		empty, err := codec.Marshal(Sparse{Enabled: true})
+		if err != nil {
+			t.Error(err)
+		}
+		if len(empty) >= len(full)/2 {
+			t.Error(fmt.Errorf("zero fields not omitted: %d bytes against %d", len(empty), len(full)))
+		}
+		var decoded Sparse
+		if err := codec.UnmarshalInto(empty, &decoded); err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(decoded, Sparse{Enabled: true}) {
+			t.Error(fmt.Errorf("before and after for sparse struct is not the same: %#v", decoded))
+		}
+		if err := codec.UnmarshalInto(full, &decoded); err != nil {
+			t.Error(err)
+		}
+		if decoded.Name != "n" || decoded.Count != 1 || decoded.Labels["l"] != 1 {
+			t.Error(fmt.Errorf("before and after for full struct is not the same: %#v", decoded))
+		}
+	}
+}
+
+func TestOmitEmptyCodecWide(t *testing.T) {
+	type Config struct {
+		A, B, C, D int
+		E          string
+	}
+	codec := NewCodec(WithOmitEmpty())
+	codec.Register(Config{})
+	serialized, err := codec.Marshal(Config{C: 3})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(serialized) != 1+4+4+9 {
+		t.Error(fmt.Errorf("zero fields not omitted: %d bytes", len(serialized)))
+	}
+	var decoded Config
+	if err := codec.UnmarshalInto(serialized, &decoded); err != nil {
+		t.Error(err)
+	}
+	if decoded != (Config{C: 3}) {
+		t.Error(fmt.Errorf("before and after for Config is not the same: %#v", decoded))
+	}
+}
+
+func TestZeroOutsideStruct(t *testing.T) {
+	if _, err := Unmarshal([]byte{byte(tagZero)}); err == nil {
+		t.Error(fmt.Errorf("no error raised for zero tag outside of a struct"))
+	}
+}
@@ -0,0 +1,223 @@
+package goser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Times used to be written as their UnixMicro value, losing nanoseconds and
+// the location. They are now written as timeEncodingZoned (which can't be
+// mistaken for the kind of the old int64) followed by the Unix seconds, the
+// nanoseconds, the zone offset in seconds, the location name and the zone
+// abbreviation. There's no monotonic clock reading in either.
+const timeEncodingZoned byte = 0x80
+
+func (e *encodeState) marshalTime(t time.Time) []byte {
+	serialized := []byte{timeEncodingZoned}
+	encodedTime := make([]byte, 16)
+	abbreviation, offset := t.Zone()
+	binary.LittleEndian.PutUint64(encodedTime, uint64(t.Unix()))
+	binary.LittleEndian.PutUint32(encodedTime[8:], uint32(t.Nanosecond()))
+	binary.LittleEndian.PutUint32(encodedTime[12:], uint32(int32(offset)))
+	serialized = append(serialized, encodedTime...)
+	serialized = e.appendLength(serialized, len(t.Location().String()))
+	serialized = append(serialized, t.Location().String()...)
+	serialized = e.appendLength(serialized, len(abbreviation))
+	return append(serialized, abbreviation...)
+}
+
+func (d *decodeState) unmarshalTime() (any, error) {
+	encoding, err := d.readKind()
+	if err != nil {
+		return nil, fmt.Errorf("can't read time encoding: %w", err)
+	}
+	switch byte(encoding) {
+	case byte(reflect.Int64):
+		timeAsInt64, err := d.unmarshalKind(reflect.Int64)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't deserialize time as int64: %w", err)
+		}
+		return time.UnixMicro(timeAsInt64.(int64)), nil
+	case timeEncodingZoned:
+		encodedTime, err := d.src.next(16)
+		if err != nil {
+			return nil, fmt.Errorf("can't read time: %w", err)
+		}
+		seconds := int64(binary.LittleEndian.Uint64(encodedTime))
+		nanoseconds := int64(binary.LittleEndian.Uint32(encodedTime[8:]))
+		offset := int(int32(binary.LittleEndian.Uint32(encodedTime[12:])))
+		if nanoseconds >= int64(time.Second) {
+			return nil, fmt.Errorf("can't deserialize time with %v nanoseconds", nanoseconds)
+		}
+		name, err := d.readString()
+		if err != nil {
+			return nil, fmt.Errorf("can't read time location: %w", err)
+		}
+		abbreviation, err := d.readString()
+		if err != nil {
+			return nil, fmt.Errorf("can't read time zone: %w", err)
+		}
+		return time.Unix(seconds, nanoseconds).In(timeLocation(seconds, offset, name, abbreviation)), nil
+	default:
+		return nil, fmt.Errorf("expected time but got kind %v", encoding)
+	}
+}
+
+// timeLocation finds the location a time was written in. UTC and the local
+// zone are recognized, other locations are loaded by name, and when that
+// doesn't give the same offset the time falls back to a fixed zone carrying
+// the original name. The local zone is named "Local", unless $TZ names a
+// zone, in which case time.Local carries that name. A local zone that is UTC
+// unmarshals as UTC.
+func timeLocation(seconds int64, offset int, name string, abbreviation string) *time.Location {
+	if offset == 0 && (name == "UTC" || abbreviation == "UTC") {
+		return time.UTC
+	}
+	if name == "Local" || name == time.Local.String() {
+		if localAbbreviation, localOffset := time.Unix(seconds, 0).In(time.Local).Zone(); localOffset == offset && localAbbreviation == abbreviation {
+			return time.Local
+		}
+		if name == "Local" {
+			return time.FixedZone(abbreviation, offset)
+		}
+	}
+	if location, err := time.LoadLocation(name); err == nil {
+		if _, locationOffset := time.Unix(seconds, 0).In(location).Zone(); locationOffset == offset {
+			return location
+		}
+	}
+	return time.FixedZone(name, offset)
+}
+
+func (d *decodeState) readString() (string, error) {
+	encodedString, err := d.readBytes()
+	return string(encodedString), err
+}
+
+// readBytes reads a length-prefixed payload. The returned slice may share
+// memory with the decoder's input.
+func (d *decodeState) readBytes() ([]byte, error) {
+	length, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	return d.src.next(length)
+}
+
+func marshalDuration(duration time.Duration) []byte {
+	encodedDuration := make([]byte, 8)
+	binary.LittleEndian.PutUint64(encodedDuration, uint64(duration))
+	return encodedDuration
+}
+
+func (d *decodeState) unmarshalDuration() (any, error) {
+	encodedDuration, err := d.src.next(8)
+	if err != nil {
+		return nil, fmt.Errorf("can't read duration: %w", err)
+	}
+	return time.Duration(binary.LittleEndian.Uint64(encodedDuration)), nil
+}
+
+// Months are written as an int, so out of range values survive too.
+func (d *decodeState) unmarshalMonth() (any, error) {
+	value, err := d.unmarshalRecursive()
+	if err != nil {
+		return nil, err
+	}
+	month, ok := value.(int)
+	if !ok {
+		return nil, &UnmarshalTypeError{Got: reflect.TypeOf(value), Want: reflect.TypeOf(time.Month(0))}
+	}
+	return time.Month(month), nil
+}
+
+// Locations are written by name, with the local zone always named "Local",
+// when the decoding side can load them by that name. Fixed zones, such as
+// the ones time.Parse makes for numeric offsets, are written as
+// locationFixed with their name and offset instead. A nil location is kept
+// apart from time.UTC, which it otherwise stands for.
+const locationFixed byte = pointerSet + 1
+
+func (e *encodeState) marshalLocation(location *time.Location) ([]byte, error) {
+	if location == nil {
+		return e.appendOptional(nil, nil, false), nil
+	}
+	if location == time.Local {
+		return e.appendOptional(nil, []byte("Local"), true), nil
+	}
+	name := location.String()
+	if offset, fixed := fixedOffset(location); fixed && !(name == "UTC" && offset == 0) {
+		serialized := e.appendLength([]byte{locationFixed}, len(name))
+		serialized = append(serialized, name...)
+		encodedOffset := make([]byte, 4)
+		binary.LittleEndian.PutUint32(encodedOffset, uint32(int32(offset)))
+		return append(serialized, encodedOffset...), nil
+	}
+	if name != "UTC" && !loadableLocation(name) {
+		return nil, fmt.Errorf("can't serialize location %q (can't be loaded by name)", name)
+	}
+	return e.appendOptional(nil, []byte(name), true), nil
+}
+
+// fixedOffset returns the offset of a location that has no transitions.
+func fixedOffset(location *time.Location) (int, bool) {
+	now := time.Now().In(location)
+	if start, end := now.ZoneBounds(); !start.IsZero() || !end.IsZero() {
+		return 0, false
+	}
+	_, offset := now.Zone()
+	return offset, true
+}
+
+var loadableLocations sync.Map
+
+func loadableLocation(name string) bool {
+	if loadable, ok := loadableLocations.Load(name); ok {
+		return loadable.(bool)
+	}
+	_, err := time.LoadLocation(name)
+	loadableLocations.Store(name, err == nil)
+	return err == nil
+}
+
+func (d *decodeState) unmarshalLocation() (any, error) {
+	status, err := d.src.next(1)
+	if err != nil {
+		return nil, fmt.Errorf("can't read location: %w", err)
+	}
+	switch status[0] {
+	case pointerNil:
+		return (*time.Location)(nil), nil
+	case locationFixed:
+		name, err := d.readString()
+		if err != nil {
+			return nil, fmt.Errorf("can't read location name: %w", err)
+		}
+		encodedOffset, err := d.src.next(4)
+		if err != nil {
+			return nil, fmt.Errorf("can't read location offset: %w", err)
+		}
+		return time.FixedZone(name, int(int32(binary.LittleEndian.Uint32(encodedOffset)))), nil
+	case pointerSet:
+	default:
+		return nil, fmt.Errorf("can't deserialize location status %v", status[0])
+	}
+	name, err := d.readString()
+	if err != nil {
+		return nil, fmt.Errorf("can't read location name: %w", err)
+	}
+	switch name {
+	case "UTC":
+		return time.UTC, nil
+	case "Local":
+		return time.Local, nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("can't load location %q: %w", name, err)
+	}
+	return location, nil
+}
@@ -0,0 +1,218 @@
+package goser
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestTimeLossless(t *testing.T) {
+	bucharest, err := time.LoadLocation("Europe/Bucharest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	times := []time.Time{
+		time.Date(2023, 7, 1, 12, 30, 0, 123456789, bucharest),
+		time.Date(2023, 1, 1, 12, 30, 0, 1, bucharest),
+		time.Date(1969, 12, 31, 23, 59, 59, 999999999, time.UTC),
+		time.Date(2024, 2, 29, 8, 0, 0, 5, time.FixedZone("Somewhere", -(3*3600+30*60))),
+		time.Date(2024, 2, 29, 8, 0, 0, 5, time.FixedZone("", 3600)),
+		time.Now(),
+		{},
+	}
+	for _, original := range times {
+		decoded, err := UnmarshalT[time.Time](mustMarshal(t, original))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !decoded.Equal(original) {
+			t.Error(fmt.Errorf("before and after for %v is not equal: %v", original, decoded))
+		}
+		originalZone, originalOffset := original.Zone()
+		decodedZone, decodedOffset := decoded.Zone()
+		if decodedZone != originalZone || decodedOffset != originalOffset {
+			t.Error(fmt.Errorf("zone of %v changed to %v %v", original, decodedZone, decodedOffset))
+		}
+		if original.Location() != time.Local && decoded.Location().String() != original.Location().String() {
+			t.Error(fmt.Errorf("location of %v changed to %v", original, decoded.Location()))
+		}
+	}
+}
+
+func TestTimeLocalNamedByTZ(t *testing.T) {
+	// With $TZ set to a zone name, time.Local carries that name rather than
+	// "Local".
+	bucharest, err := time.LoadLocation("Europe/Bucharest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := time.Local
+	time.Local = bucharest
+	defer func() { time.Local = local }()
+	original := time.Date(2023, 7, 1, 12, 30, 0, 0, time.Local)
+	decoded, err := UnmarshalT[time.Time](mustMarshal(t, original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded != original {
+		t.Error(fmt.Errorf("before and after for local time %v is not the same: %v", original, decoded))
+	}
+}
+
+func TestTimeInStruct(t *testing.T) {
+	type Audit struct {
+		At      time.Time
+		Expires *time.Time
+	}
+	codec := NewCodec()
+	codec.MustRegister(Audit{})
+	at := time.Date(2022, 3, 4, 5, 6, 7, 8, time.UTC)
+	original := Audit{At: at, Expires: &at}
+	serialized, err := codec.Marshal(original)
+	if err != nil {
+		t.Error(err)
+	}
+	var decoded Audit
+	if err := codec.UnmarshalInto(serialized, &decoded); err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Error(fmt.Errorf("before and after for Audit is not the same: %#v", decoded))
+	}
+}
+
+func TestTimeLegacyMicroseconds(t *testing.T) {
+	original := time.Date(2020, 5, 6, 7, 8, 9, 10000, time.UTC)
+	bytes := append([]byte{byte(reflect.Struct), 't', 'i', 'm', 'e'}, mustMarshal(t, original.UnixMicro())...)
+	decoded, err := Unmarshal(bytes)
+	if err != nil {
+		t.Error(err)
+	}
+	if !decoded.(time.Time).Equal(original) {
+		t.Error(fmt.Errorf("legacy time decoded as %v", decoded))
+	}
+}
+
+func TestTimeInvalidNanoseconds(t *testing.T) {
+	bytes := append([]byte{byte(reflect.Struct), 't', 'i', 'm', 'e'}, (&encodeState{}).marshalTime(time.Unix(0, 0).UTC())...)
+	bytes[14] = 0xff
+	bytes[15] = 0xff
+	bytes[16] = 0xff
+	bytes[17] = 0xff
+	if _, err := Unmarshal(bytes); err == nil {
+		t.Error(fmt.Errorf("no error raised for invalid nanoseconds"))
+	}
+}
+
+func TestDuration(t *testing.T) {
+	original := []any{90 * time.Minute, -time.Nanosecond, time.Duration(0)}
+	decoded, err := Unmarshal(mustMarshal(t, original))
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Error(fmt.Errorf("before and after for durations is not the same: %#v", decoded))
+	}
+}
+
+func TestLocation(t *testing.T) {
+	bucharest, err := time.LoadLocation("Europe/Bucharest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, original := range []*time.Location{bucharest, time.UTC, time.Local, nil} {
+		decoded, err := UnmarshalT[*time.Location](mustMarshal(t, original))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if decoded.String() != original.String() || (original == nil) != (decoded == nil) {
+			t.Error(fmt.Errorf("before and after for location %v is not the same: %v", original, decoded))
+		}
+	}
+	decoded, err := UnmarshalT[*time.Location](mustMarshal(t, time.Local))
+	if err != nil {
+		t.Error(err)
+	}
+	if decoded != time.Local {
+		t.Error(fmt.Errorf("local location decoded as %v", decoded))
+	}
+}
+
+func TestLocationInStruct(t *testing.T) {
+	type Schedule struct {
+		Every    time.Duration
+		Location *time.Location
+	}
+	codec := NewCodec()
+	codec.MustRegister(Schedule{})
+	original := Schedule{Every: time.Hour, Location: time.UTC}
+	serialized, err := codec.Marshal(original)
+	if err != nil {
+		t.Error(err)
+	}
+	var decoded Schedule
+	if err := codec.UnmarshalInto(serialized, &decoded); err != nil {
+		t.Error(err)
+	}
+	if decoded != original {
+		t.Error(fmt.Errorf("before and after for Schedule is not the same: %#v", decoded))
+	}
+}
+
+func TestLocationFixed(t *testing.T) {
+	parsed, err := time.Parse(time.RFC3339, "2024-06-01T10:00:00+02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, original := range []*time.Location{parsed.Location(), time.FixedZone("CEST", 7200), time.FixedZone("Nowhere/Special", -3600)} {
+		decoded, err := UnmarshalT[*time.Location](mustMarshal(t, original))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		originalZone, originalOffset := parsed.In(original).Zone()
+		decodedZone, decodedOffset := parsed.In(decoded).Zone()
+		if decoded.String() != original.String() || decodedZone != originalZone || decodedOffset != originalOffset {
+			t.Error(fmt.Errorf("before and after for location %q is not the same: %q %v", original, decodedZone, decodedOffset))
+		}
+	}
+}
+
+func TestLocationUnknown(t *testing.T) {
+	// A zone named "Custom/Zone" switching from AAA (+1h) to BBB (+2h) at
+	// the epoch, as TZif version 1 data.
+	tzdata := []byte("TZif")
+	tzdata = append(tzdata, make([]byte, 16)...)
+	tzdata = append(tzdata, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 8)
+	tzdata = append(tzdata, 0, 0, 0, 0, 1)
+	tzdata = append(tzdata, 0, 0, 0x0e, 0x10, 0, 0, 0, 0, 0x1c, 0x20, 0, 4)
+	tzdata = append(tzdata, "AAA\x00BBB\x00"...)
+	location, err := time.LoadLocationFromTZData("Custom/Zone", tzdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Marshal(location); err == nil {
+		t.Error(fmt.Errorf("no error raised for unknown location"))
+	}
+}
+
+func TestMonth(t *testing.T) {
+	original := []any{time.March, time.December, time.Month(13)}
+	for _, codec := range []*Codec{NewCodec(), NewCodec(WithCompact())} {
+		serialized, err := codec.Marshal(original)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := codec.Unmarshal(serialized)
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(decoded, original) {
+			t.Error(fmt.Errorf("before and after for months is not the same: %#v", decoded))
+		}
+	}
+}
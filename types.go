@@ -0,0 +1,193 @@
+package goser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// marshalType writes a description of a type rather than a value, used
+// where there is no value to derive the type from, such as nil pointers.
+// Scalars are described by their kind, composite types by their kind and
+// the description of their parts, well-known types by their well-known id,
+// and structs, registered named types and types with a custom codec by
+// their type id.
+func (e *encodeState) marshalType(theType reflect.Type) ([]byte, error) {
+	kind := theType.Kind()
+	if kind == reflect.Interface {
+		return []byte{byte(tagInterface)}, nil
+	}
+	if typeId, custom, _ := e.codec.registry.lookup(theType); custom != nil {
+		encodedTypeId := make([]byte, 4)
+		binary.LittleEndian.PutUint32(encodedTypeId, typeId)
+		return append([]byte{byte(tagCustom)}, encodedTypeId...), nil
+	}
+	if wellKnownId, isWellKnown := wellKnownIds[theType]; isWellKnown {
+		return []byte{byte(tagWellKnown), wellKnownId}, nil
+	}
+	if theType.PkgPath() != "" && kind != reflect.Struct {
+		if typeId, typeKnown := e.codec.registry.idByType(theType); typeKnown && underlyingType(theType) != nil {
+			encodedTypeId := make([]byte, 4)
+			binary.LittleEndian.PutUint32(encodedTypeId, typeId)
+			return append([]byte{byte(tagNamed)}, encodedTypeId...), nil
+		}
+	}
+	serialized := []byte{byte(kind)}
+	switch kind {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128, reflect.String:
+		return serialized, nil
+	case reflect.Pointer, reflect.Slice:
+		encodedElem, err := e.marshalType(theType.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return append(serialized, encodedElem...), nil
+	case reflect.Array:
+		serialized = e.appendLength(serialized, theType.Len())
+		encodedElem, err := e.marshalType(theType.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return append(serialized, encodedElem...), nil
+	case reflect.Map:
+		encodedKey, err := e.marshalType(theType.Key())
+		if err != nil {
+			return nil, err
+		}
+		encodedElem, err := e.marshalType(theType.Elem())
+		if err != nil {
+			return nil, err
+		}
+		serialized = append(serialized, encodedKey...)
+		return append(serialized, encodedElem...), nil
+	case reflect.Struct:
+		typeId, typeKnown := e.codec.registry.idByType(theType)
+		if !typeKnown {
+			return nil, fmt.Errorf("can't serialize type %v (not registered)", theType)
+		}
+		encodedTypeId := make([]byte, 4)
+		binary.LittleEndian.PutUint32(encodedTypeId, typeId)
+		return append(serialized, encodedTypeId...), nil
+	default:
+		return nil, fmt.Errorf("can't serialize type %v", theType)
+	}
+}
+
+// Nil slices and maps are written as tagNil followed by their type, which
+// keeps them apart from empty ones. When the type can't be described they
+// are written as empty instead.
+func (e *encodeState) marshalNil(theType reflect.Type) ([]byte, error) {
+	encodedType, err := e.marshalType(theType)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{byte(tagNil)}, encodedType...), nil
+}
+
+func (d *decodeState) unmarshalNil() (any, error) {
+	theType, err := d.unmarshalType()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't deserialize nil type: %w", err)
+	}
+	if theType.Kind() != reflect.Slice && theType.Kind() != reflect.Map {
+		return nil, fmt.Errorf("can't deserialize nil %v", theType)
+	}
+	return reflect.Zero(theType).Interface(), nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (d *decodeState) unmarshalType() (reflect.Type, error) {
+	kind, err := d.readKind()
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case tagInterface:
+		return interfaceType, nil
+	case tagCustom:
+		encodedTypeId, err := d.src.next(4)
+		if err != nil {
+			return nil, fmt.Errorf("can't read type id: %w", err)
+		}
+		typeId := binary.LittleEndian.Uint32(encodedTypeId)
+		theType, _, hasCodec := d.codec.registry.codecById(typeId)
+		if !hasCodec {
+			return nil, fmt.Errorf("can't deserialize type id %v (no codec registered)", typeId)
+		}
+		return theType, nil
+	case tagWellKnown:
+		wellKnown, err := d.readWellKnown()
+		if err != nil {
+			return nil, err
+		}
+		return wellKnown.theType, nil
+	case tagNamed:
+		encodedTypeId, err := d.src.next(4)
+		if err != nil {
+			return nil, fmt.Errorf("can't read named type id: %w", err)
+		}
+		typeId := binary.LittleEndian.Uint32(encodedTypeId)
+		theType, typeKnown := d.codec.registry.typeById(typeId)
+		if !typeKnown {
+			return nil, fmt.Errorf("can't deserialize type id %v (not registered)", typeId)
+		}
+		return theType, nil
+	case reflect.Pointer:
+		elemType, err := d.unmarshalType()
+		if err != nil {
+			return nil, err
+		}
+		return reflect.PointerTo(elemType), nil
+	case reflect.Slice:
+		elemType, err := d.unmarshalType()
+		if err != nil {
+			return nil, err
+		}
+		return reflect.SliceOf(elemType), nil
+	case reflect.Array:
+		length, err := d.readLength()
+		if err != nil {
+			return nil, fmt.Errorf("can't read array type length: %w", err)
+		}
+		elemType, err := d.unmarshalType()
+		if err != nil {
+			return nil, err
+		}
+		return arrayOf(length, elemType)
+	case reflect.Map:
+		keyType, err := d.unmarshalType()
+		if err != nil {
+			return nil, err
+		}
+		if !keyType.Comparable() {
+			return nil, fmt.Errorf("can't deserialize map with key type %v", keyType)
+		}
+		elemType, err := d.unmarshalType()
+		if err != nil {
+			return nil, err
+		}
+		return reflect.MapOf(keyType, elemType), nil
+	case reflect.Struct:
+		encodedTypeId, err := d.src.next(4)
+		if err != nil {
+			return nil, fmt.Errorf("can't read struct type id: %w", err)
+		}
+		if string(encodedTypeId) == "time" {
+			return timeType, nil
+		}
+		typeId := binary.LittleEndian.Uint32(encodedTypeId)
+		theType, typeKnown := d.codec.registry.typeById(typeId)
+		if !typeKnown {
+			return nil, fmt.Errorf("can't deserialize type id %v (not registered)", typeId)
+		}
+		return theType, nil
+	}
+	if theType, ok := kindTypes[kind]; ok {
+		return theType, nil
+	}
+	return nil, fmt.Errorf("can't deserialize type of kind %v", kind)
+}
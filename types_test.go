@@ -0,0 +1,138 @@
+package goser
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTypedNil(t *testing.T) {
+	type User struct {
+		name string
+	}
+	codec := NewCodec()
+	codec.MustRegister(User{})
+	codec.MustRegister(Status(0))
+	values := []any{
+		(*User)(nil),
+		(**User)(nil),
+		(*[]int)(nil),
+		(*map[string][2]bool)(nil),
+		(*Status)(nil),
+		(*time.Time)(nil),
+		(*any)(nil),
+		[]*User{nil, nil},
+		[]any{(*User)(nil), nil},
+	}
+	for _, value := range values {
+		serialized, err := codec.Marshal(value)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		decoded, err := codec.Unmarshal(serialized)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded, value) {
+			t.Error(fmt.Errorf("before and after for %T is not the same: %#v", value, decoded))
+		}
+	}
+}
+
+func TestTypedNilUnregistered(t *testing.T) {
+	type NotRegistered struct{}
+	decoded, err := Unmarshal(mustMarshal(t, (*NotRegistered)(nil)))
+	if err != nil {
+		t.Error(err)
+	}
+	if decoded != nil {
+		t.Error(fmt.Errorf("expected untyped nil for unregistered pointer type, got %#v", decoded))
+	}
+}
+
+func TestTypedNilInvalidType(t *testing.T) {
+	bytes := []byte{byte(reflect.Pointer), pointerTypedNil, byte(reflect.Chan)}
+	if _, err := Unmarshal(bytes); err == nil {
+		t.Error(fmt.Errorf("no error raised for nil pointer to chan"))
+	}
+	bytes = []byte{byte(reflect.Pointer), pointerTypedNil, byte(reflect.Map), byte(reflect.Slice), byte(reflect.Int), byte(reflect.Int)}
+	if _, err := Unmarshal(bytes); err == nil {
+		t.Error(fmt.Errorf("no error raised for nil pointer to map with slice keys"))
+	}
+}
+
+func TestPointerToUntypedNil(t *testing.T) {
+	bytes := []byte{byte(reflect.Pointer), pointerSet, byte(reflect.Pointer), pointerNil}
+	if _, err := Unmarshal(bytes); err == nil {
+		t.Error(fmt.Errorf("no error raised for pointer to untyped nil"))
+	}
+}
+
+func TestNilCollections(t *testing.T) {
+	type Tags []string
+	type Request struct {
+		filters []string
+		cleared []string
+		labels  map[string]int
+		emptied map[string]int
+		tags    Tags
+	}
+	codec := NewCodec()
+	codec.MustRegister(Request{})
+	codec.MustRegister(Tags{})
+	original := Request{cleared: []string{}, emptied: map[string]int{}}
+	serialized, err := codec.Marshal(original)
+	if err != nil {
+		t.Error(err)
+	}
+	var decoded Request
+	if err := codec.UnmarshalInto(serialized, &decoded); err != nil {
+		t.Error(err)
+	}
+	if decoded.filters != nil || decoded.labels != nil || decoded.tags != nil {
+		t.Error(fmt.Errorf("nil collections decoded as non-nil: %#v", decoded))
+	}
+	if decoded.cleared == nil || decoded.emptied == nil {
+		t.Error(fmt.Errorf("empty collections decoded as nil: %#v", decoded))
+	}
+
+	values := []any{[]int(nil), []int{}, map[string]bool(nil), map[string]bool{}, [][]byte{nil, {}}, Tags(nil)}
+	serialized, err = codec.Marshal(values)
+	if err != nil {
+		t.Error(err)
+	}
+	decodedValues, err := codec.Unmarshal(serialized)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(decodedValues, values) {
+		t.Error(fmt.Errorf("before and after for nil and empty collections is not the same: %#v", decodedValues))
+	}
+}
+
+func TestNilOfOtherKind(t *testing.T) {
+	bytes := []byte{byte(tagNil), byte(reflect.Int)}
+	if _, err := Unmarshal(bytes); err == nil {
+		t.Error(fmt.Errorf("no error raised for nil int"))
+	}
+}
+
+func TestHugeArrayType(t *testing.T) {
+	huge := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}
+	arrayType := append(append([]byte{byte(reflect.Array)}, huge...), byte(reflect.Int))
+	payloads := map[string][]byte{
+		"nil slice":     append([]byte{byte(tagNil), byte(reflect.Slice)}, arrayType...),
+		"nil array":     append([]byte{byte(tagNil)}, arrayType...),
+		"typed nil":     append([]byte{byte(reflect.Pointer), pointerTypedNil}, arrayType...),
+		"tracked":       append([]byte{byte(tagTracked), 0, 0, 0, 0, 0, 0, 0, 0, byte(reflect.Pointer)}, arrayType...),
+		"tracked items": append([]byte{byte(tagTracked), 0, 0, 0, 0, 0, 0, 0, 0, byte(reflect.Pointer), byte(reflect.Array), 0, 0, 0, 0x10, 0, 0, 0, 0, byte(reflect.Int)}, mustMarshal(t, 1)...),
+	}
+	for name, payload := range payloads {
+		if _, err := Unmarshal(payload); err == nil {
+			t.Error(fmt.Errorf("no error raised for %v", name))
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package goser
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"time"
+)
+
+// Well-known types are encoded by the package itself rather than through the
+// registry. They are written as tagWellKnown followed by a one-byte id from
+// wellKnownTypes and the type's own payload, so they can't be confused with
+// registered struct ids. Ids are part of the wire format and must never be
+// reused.
+type wellKnownType struct {
+	theType   reflect.Type
+	marshal   func(e *encodeState, value reflect.Value) ([]byte, error)
+	unmarshal func(d *decodeState) (any, error)
+}
+
+const (
+	wellKnownTime byte = iota + 1
+	wellKnownDuration
+	wellKnownLocation
+	wellKnownBigInt
+	wellKnownBigFloat
+	wellKnownBigRat
+	wellKnownIP
+	wellKnownIPNet
+	wellKnownAddr
+	wellKnownAddrPort
+	wellKnownPrefix
+	wellKnownURL
+	wellKnownMonth
+)
+
+var (
+	wellKnownTypes = map[byte]wellKnownType{}
+	wellKnownIds   = map[reflect.Type]byte{}
+)
+
+// The table is filled in init as the decoders refer back to it through
+// unmarshalRecursive.
+func init() {
+	addWellKnown(wellKnownTime, timeType, func(e *encodeState, value reflect.Value) ([]byte, error) {
+		return e.marshalTime(value.Interface().(time.Time)), nil
+	}, (*decodeState).unmarshalTime)
+	addWellKnown(wellKnownDuration, reflect.TypeOf(time.Duration(0)), func(e *encodeState, value reflect.Value) ([]byte, error) {
+		return marshalDuration(time.Duration(value.Int())), nil
+	}, (*decodeState).unmarshalDuration)
+	addWellKnown(wellKnownLocation, reflect.TypeOf((*time.Location)(nil)), func(e *encodeState, value reflect.Value) ([]byte, error) {
+		return e.marshalLocation(value.Interface().(*time.Location))
+	}, (*decodeState).unmarshalLocation)
+	addWellKnown(wellKnownBigInt, reflect.TypeOf((*big.Int)(nil)), func(e *encodeState, value reflect.Value) ([]byte, error) {
+		return e.marshalBigInt(value.Interface().(*big.Int)), nil
+	}, (*decodeState).unmarshalBigInt)
+	addWellKnown(wellKnownBigFloat, reflect.TypeOf((*big.Float)(nil)), func(e *encodeState, value reflect.Value) ([]byte, error) {
+		return e.marshalBigFloat(value.Interface().(*big.Float))
+	}, (*decodeState).unmarshalBigFloat)
+	addWellKnown(wellKnownBigRat, reflect.TypeOf((*big.Rat)(nil)), func(e *encodeState, value reflect.Value) ([]byte, error) {
+		return e.marshalBigRat(value.Interface().(*big.Rat))
+	}, (*decodeState).unmarshalBigRat)
+	addWellKnown(wellKnownIP, ipType, func(e *encodeState, value reflect.Value) ([]byte, error) {
+		return e.marshalIP(value.Interface().(net.IP)), nil
+	}, (*decodeState).unmarshalIP)
+	addWellKnown(wellKnownIPNet, ipNetType, func(e *encodeState, value reflect.Value) ([]byte, error) {
+		return e.marshalIPNet(value.Interface().(net.IPNet)), nil
+	}, (*decodeState).unmarshalIPNet)
+	addWellKnown(wellKnownAddr, addrType, func(e *encodeState, value reflect.Value) ([]byte, error) {
+		return e.appendBinary(value.Interface().(netip.Addr))
+	}, unmarshalBinaryInto[netip.Addr]())
+	addWellKnown(wellKnownAddrPort, addrPortType, func(e *encodeState, value reflect.Value) ([]byte, error) {
+		return e.appendBinary(value.Interface().(netip.AddrPort))
+	}, unmarshalBinaryInto[netip.AddrPort]())
+	addWellKnown(wellKnownPrefix, prefixType, func(e *encodeState, value reflect.Value) ([]byte, error) {
+		return e.appendBinary(value.Interface().(netip.Prefix))
+	}, unmarshalBinaryInto[netip.Prefix]())
+	addWellKnown(wellKnownURL, urlType, func(e *encodeState, value reflect.Value) ([]byte, error) {
+		address := value.Interface().(url.URL)
+		return e.appendBinary(&address)
+	}, unmarshalBinaryInto[url.URL]())
+	addWellKnown(wellKnownMonth, reflect.TypeOf(time.Month(0)), func(e *encodeState, value reflect.Value) ([]byte, error) {
+		return e.marshal(int(value.Int()))
+	}, (*decodeState).unmarshalMonth)
+}
+
+func addWellKnown(id byte, theType reflect.Type, marshal func(*encodeState, reflect.Value) ([]byte, error), unmarshal func(*decodeState) (any, error)) {
+	wellKnownTypes[id] = wellKnownType{theType: theType, marshal: marshal, unmarshal: unmarshal}
+	wellKnownIds[theType] = id
+}
+
+// legacyTimeId is the struct type id older versions wrote time.Time under
+// (the bytes "time"). It is still decoded, so Register refuses it.
+const legacyTimeId uint32 = 0x656d6974
+
+func (e *encodeState) marshalWellKnown(id byte, value reflect.Value) ([]byte, error) {
+	payload, err := wellKnownTypes[id].marshal(e, value)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't serialize %v: %w", value.Type(), err)
+	}
+	return append([]byte{byte(tagWellKnown), id}, payload...), nil
+}
+
+func (d *decodeState) unmarshalWellKnown() (any, error) {
+	wellKnown, err := d.readWellKnown()
+	if err != nil {
+		return nil, err
+	}
+	value, err := wellKnown.unmarshal(d)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't deserialize %v: %w", wellKnown.theType, err)
+	}
+	return value, nil
+}
+
+func (d *decodeState) readWellKnown() (wellKnownType, error) {
+	encodedId, err := d.src.next(1)
+	if err != nil {
+		return wellKnownType{}, fmt.Errorf("can't read well-known type id: %w", err)
+	}
+	wellKnown, ok := wellKnownTypes[encodedId[0]]
+	if !ok {
+		return wellKnownType{}, fmt.Errorf("can't deserialize well-known type id %v", encodedId[0])
+	}
+	return wellKnown, nil
+}
+
+// appendOptional writes the payload of a well-known pointer type: pointerNil
+// for nil, or pointerSet followed by the length-prefixed payload.
+func (e *encodeState) appendOptional(serialized []byte, payload []byte, present bool) []byte {
+	if !present {
+		return append(serialized, pointerNil)
+	}
+	serialized = e.appendLength(append(serialized, pointerSet), len(payload))
+	return append(serialized, payload...)
+}
+
+func (d *decodeState) readOptional() ([]byte, bool, error) {
+	status, err := d.src.next(1)
+	if err != nil {
+		return nil, false, err
+	}
+	switch status[0] {
+	case pointerNil:
+		return nil, false, nil
+	case pointerSet:
+	default:
+		return nil, false, fmt.Errorf("can't deserialize pointer status %v", status[0])
+	}
+	length, err := d.readLength()
+	if err != nil {
+		return nil, false, err
+	}
+	payload, err := d.src.next(length)
+	if err != nil {
+		return nil, false, err
+	}
+	return payload, true, nil
+}
@@ -0,0 +1,63 @@
+package goser
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWellKnownTime(t *testing.T) {
+	original := time.Date(2021, 9, 10, 11, 12, 13, 14, time.UTC)
+	serialized := mustMarshal(t, original)
+	if serialized[0] != byte(tagWellKnown) || serialized[1] != wellKnownTime {
+		t.Error(fmt.Errorf("time not written as well-known: % x", serialized[:2]))
+	}
+	decoded, err := UnmarshalT[time.Time](serialized)
+	if err != nil {
+		t.Error(err)
+	}
+	if !decoded.Equal(original) {
+		t.Error(fmt.Errorf("before and after for time is not equal: %v", decoded))
+	}
+}
+
+func TestWellKnownTypedNil(t *testing.T) {
+	serialized := mustMarshal(t, (*time.Time)(nil))
+	decoded, err := Unmarshal(serialized)
+	if err != nil {
+		t.Error(err)
+	}
+	if reflect.TypeOf(decoded) != reflect.TypeOf((*time.Time)(nil)) || decoded.(*time.Time) != nil {
+		t.Error(fmt.Errorf("typed nil time decoded as %#v", decoded))
+	}
+}
+
+func TestWellKnownLegacyDescriptor(t *testing.T) {
+	bytes := []byte{byte(reflect.Pointer), pointerTypedNil, byte(reflect.Struct), 't', 'i', 'm', 'e'}
+	decoded, err := Unmarshal(bytes)
+	if err != nil {
+		t.Error(err)
+	}
+	if reflect.TypeOf(decoded) != reflect.TypeOf((*time.Time)(nil)) {
+		t.Error(fmt.Errorf("legacy typed nil time decoded as %#v", decoded))
+	}
+}
+
+func TestWellKnownUnknownId(t *testing.T) {
+	if _, err := Unmarshal([]byte{byte(tagWellKnown), 0xff}); err == nil {
+		t.Error(fmt.Errorf("no error raised for unknown well-known id"))
+	}
+}
+
+func TestRegisterReservedID(t *testing.T) {
+	type Reserved struct{ value int }
+	codec := NewCodec()
+	if err := codec.RegisterWithID(Reserved{}, legacyTimeId); !errors.Is(err, ErrReservedID) {
+		t.Error(fmt.Errorf("expected ErrReservedID, got %v", err))
+	}
+	if err := codec.RegisterAlias(Reserved{}, legacyTimeId); !errors.Is(err, ErrReservedID) {
+		t.Error(fmt.Errorf("expected ErrReservedID, got %v", err))
+	}
+}